@@ -1,9 +1,11 @@
 package account
 
 type account struct {
-	id       string
-	name     string
-	currency string
+	id        string
+	name      string
+	currency  string
+	labels    []string
+	isDefault bool
 }
 
 func (a *account) Name() string {
@@ -17,3 +19,11 @@ func (a *account) Currency() string {
 func (a *account) ID() string {
 	return a.id
 }
+
+func (a *account) Labels() []string {
+	return a.labels
+}
+
+func (a *account) IsDefault() bool {
+	return a.isDefault
+}
@@ -0,0 +1,165 @@
+package account
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	FIFOMethod        = "fifo"
+	AverageCostMethod = "average"
+)
+
+// costTrade is a trade of an account from the point of view of its currency.
+type costTrade struct {
+	// disposal is set when the account currency was sold, otherwise it was bought
+	disposal bool
+	quantity *big.Rat
+	// value is what was paid for or received from the trade, in the reference currency
+	value *big.Rat
+}
+
+type costBasisResult struct {
+	// position is the quantity still held out of the tracked acquisitions, and cost what it cost
+	position *big.Rat
+	cost     *big.Rat
+	realized *big.Rat
+	// untracked is the quantity disposed of beyond the tracked acquisitions, e.g. deposited funds.
+	// Its cost is unknown, so it is left out of the realized PnL.
+	untracked *big.Rat
+}
+
+type costBasis interface {
+	acquire(quantity *big.Rat, cost *big.Rat)
+	// dispose returns the cost of the disposed quantity and the quantity that was not held
+	dispose(quantity *big.Rat) (cost *big.Rat, untracked *big.Rat)
+	position() (quantity *big.Rat, cost *big.Rat)
+}
+
+func newCostBasis(method string) (costBasis, error) {
+	switch method {
+	case FIFOMethod:
+		return &fifoCostBasis{}, nil
+	case AverageCostMethod:
+		return &averageCostBasis{quantity: new(big.Rat), cost: new(big.Rat)}, nil
+	default:
+		return nil, fmt.Errorf("unknown cost basis method: %s", method)
+	}
+}
+
+// computeCostBasis replays trades in order and returns the realized PnL and the remaining position.
+func computeCostBasis(method string, trades []costTrade) (costBasisResult, error) {
+	basis, err := newCostBasis(method)
+	if err != nil {
+		return costBasisResult{}, err
+	}
+
+	realized := new(big.Rat)
+	untrackedTotal := new(big.Rat)
+	for _, trade := range trades {
+		if !trade.disposal {
+			basis.acquire(trade.quantity, trade.value)
+			continue
+		}
+
+		cost, untracked := basis.dispose(trade.quantity)
+		// Only the proceeds of the tracked part are matched against its cost
+		var trackedQuantity, proceeds big.Rat
+		trackedQuantity.Sub(trade.quantity, untracked)
+		if trade.quantity.Sign() > 0 {
+			proceeds.Mul(trade.value, &trackedQuantity)
+			proceeds.Quo(&proceeds, trade.quantity)
+		}
+		realized.Add(realized, &proceeds)
+		realized.Sub(realized, cost)
+		untrackedTotal.Add(untrackedTotal, untracked)
+	}
+
+	position, cost := basis.position()
+	return costBasisResult{
+		position:  position,
+		cost:      cost,
+		realized:  realized,
+		untracked: untrackedTotal,
+	}, nil
+}
+
+type lot struct {
+	quantity *big.Rat
+	cost     *big.Rat
+}
+
+// fifoCostBasis disposes of the oldest lots first.
+type fifoCostBasis struct {
+	lots []lot
+}
+
+func (f *fifoCostBasis) acquire(quantity *big.Rat, cost *big.Rat) {
+	f.lots = append(f.lots, lot{quantity: new(big.Rat).Set(quantity), cost: new(big.Rat).Set(cost)})
+}
+
+func (f *fifoCostBasis) dispose(quantity *big.Rat) (*big.Rat, *big.Rat) {
+	remaining := new(big.Rat).Set(quantity)
+	cost := new(big.Rat)
+	for len(f.lots) > 0 && remaining.Sign() > 0 {
+		oldest := &f.lots[0]
+		if oldest.quantity.Cmp(remaining) <= 0 {
+			cost.Add(cost, oldest.cost)
+			remaining.Sub(remaining, oldest.quantity)
+			f.lots = f.lots[1:]
+			continue
+		}
+
+		// Partially consume the oldest lot at its unit cost
+		var partCost big.Rat
+		partCost.Mul(oldest.cost, remaining)
+		partCost.Quo(&partCost, oldest.quantity)
+		cost.Add(cost, &partCost)
+		oldest.cost.Sub(oldest.cost, &partCost)
+		oldest.quantity.Sub(oldest.quantity, remaining)
+		remaining.SetInt64(0)
+	}
+	return cost, remaining
+}
+
+func (f *fifoCostBasis) position() (*big.Rat, *big.Rat) {
+	quantity, cost := new(big.Rat), new(big.Rat)
+	for _, l := range f.lots {
+		quantity.Add(quantity, l.quantity)
+		cost.Add(cost, l.cost)
+	}
+	return quantity, cost
+}
+
+// averageCostBasis disposes of every unit at the average cost of the position.
+type averageCostBasis struct {
+	quantity *big.Rat
+	cost     *big.Rat
+}
+
+func (a *averageCostBasis) acquire(quantity *big.Rat, cost *big.Rat) {
+	a.quantity.Add(a.quantity, quantity)
+	a.cost.Add(a.cost, cost)
+}
+
+func (a *averageCostBasis) dispose(quantity *big.Rat) (*big.Rat, *big.Rat) {
+	tracked := new(big.Rat).Set(quantity)
+	untracked := new(big.Rat)
+	if tracked.Cmp(a.quantity) > 0 {
+		untracked.Sub(tracked, a.quantity)
+		tracked.Set(a.quantity)
+	}
+	if tracked.Sign() == 0 {
+		return new(big.Rat), untracked
+	}
+
+	cost := new(big.Rat).Mul(a.cost, tracked)
+	cost.Quo(cost, a.quantity)
+	a.cost.Sub(a.cost, cost)
+	a.quantity.Sub(a.quantity, tracked)
+	return cost, untracked
+}
+
+func (a *averageCostBasis) position() (*big.Rat, *big.Rat) {
+	return new(big.Rat).Set(a.quantity), new(big.Rat).Set(a.cost)
+}
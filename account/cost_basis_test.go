@@ -0,0 +1,98 @@
+package account
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buy(quantity string, value string) costTrade {
+	q, _ := new(big.Rat).SetString(quantity)
+	v, _ := new(big.Rat).SetString(value)
+	return costTrade{quantity: q, value: v}
+}
+
+func sell(quantity string, value string) costTrade {
+	trade := buy(quantity, value)
+	trade.disposal = true
+	return trade
+}
+
+func TestComputeCostBasis(t *testing.T) {
+	trades := []costTrade{
+		buy("1", "100"),
+		buy("1", "200"),
+		sell("1.5", "375"),
+	}
+
+	tests := []struct {
+		name              string
+		method            string
+		trades            []costTrade
+		expectedRealized  string
+		expectedPosition  string
+		expectedCost      string
+		expectedUntracked string
+	}{
+		{
+			name:   "FIFO",
+			method: FIFOMethod,
+			trades: trades,
+			// 375 - (100 + 0.5 * 200)
+			expectedRealized:  "175.00000000",
+			expectedPosition:  "0.50000000",
+			expectedCost:      "100.00000000",
+			expectedUntracked: "0.00000000",
+		},
+		{
+			name:   "AverageCost",
+			method: AverageCostMethod,
+			trades: trades,
+			// 375 - 1.5 * 150
+			expectedRealized:  "150.00000000",
+			expectedPosition:  "0.50000000",
+			expectedCost:      "75.00000000",
+			expectedUntracked: "0.00000000",
+		},
+		{
+			name:   "UntrackedDisposal",
+			method: FIFOMethod,
+			trades: []costTrade{
+				buy("1", "100"),
+				sell("2", "300"),
+			},
+			// Only half of the proceeds match the tracked lot
+			expectedRealized:  "50.00000000",
+			expectedPosition:  "0.00000000",
+			expectedCost:      "0.00000000",
+			expectedUntracked: "1.00000000",
+		},
+		{
+			name:   "KeepsEightDecimalsOfLargeAmounts",
+			method: FIFOMethod,
+			trades: []costTrade{
+				buy("123456789012.12345678", "98765432109.87654321"),
+				sell("0.00000001", "0.00000002"),
+			},
+			expectedRealized:  "0.00000001",
+			expectedPosition:  "123456789012.12345677",
+			expectedCost:      "98765432109.87654320",
+			expectedUntracked: "0.00000000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := computeCostBasis(tt.method, tt.trades)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedRealized, result.realized.FloatString(8))
+			assert.Equal(t, tt.expectedPosition, result.position.FloatString(8))
+			assert.Equal(t, tt.expectedCost, result.cost.FloatString(8))
+			assert.Equal(t, tt.expectedUntracked, result.untracked.FloatString(8))
+		})
+	}
+
+	_, err := computeCostBasis("lifo", trades)
+	assert.Error(t, err)
+}
@@ -0,0 +1,99 @@
+package account
+
+import (
+	"account-operator/code"
+	"account-operator/postgresql"
+	"database/sql"
+	"fmt"
+)
+
+const (
+	// NotFrozen accounts can do anything
+	NotFrozen = "none"
+	// DebitFrozen accounts can still receive deposits but cannot withdraw or trade
+	DebitFrozen = "debit"
+	// FullyFrozen accounts can do nothing at all
+	FullyFrozen = "full"
+)
+
+func (o *operator) FreezeAccount(actorID string, accountID string, state string, reason string) error {
+	if state != DebitFrozen && state != FullyFrozen {
+		return fmt.Errorf("%w : invalid freeze state: %s", code.InvalidRequest, state)
+	}
+	return setFreezeState(actorID, accountID, state, reason)
+}
+
+func (o *operator) UnfreezeAccount(actorID string, accountID string, reason string) error {
+	return setFreezeState(actorID, accountID, NotFrozen, reason)
+}
+
+func setFreezeState(actorID string, accountID string, state string, reason string) error {
+	dbClient := postgresql.GetClient()
+
+	// Start a transaction
+	tx, err := dbClient.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	updateQuery := `
+		UPDATE account
+		SET freeze_state = $1, freeze_reason = $2
+		WHERE id = $3;
+	`
+	result, err := tx.Exec(updateQuery, state, reason, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to update freeze state: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update freeze state: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w : account: %s", code.AccountNotFound, accountID)
+	}
+
+	// Keep a trail of who froze or unfroze the account and why
+	logQuery := `
+		INSERT INTO account_freeze_log (account, freeze_state, reason, actor)
+		VALUES ($1, $2, $3, $4);
+	`
+	_, err = tx.Exec(logQuery, accountID, state, reason, actorID)
+	if err != nil {
+		return fmt.Errorf("failed to log freeze state: %w", err)
+	}
+
+	// Commit the transaction
+	if commitErr := tx.Commit(); commitErr != nil {
+		return fmt.Errorf("failed to commit transaction: %w", commitErr)
+	}
+	return nil
+}
+
+func checkIfFrozen(tx *sql.Tx, accountID string) (state string, err error) {
+	err = tx.QueryRow("SELECT freeze_state FROM account WHERE id = $1", accountID).Scan(&state)
+	if err != nil {
+		return "", fmt.Errorf("failed to check if account is frozen: %w", err)
+	}
+	return state, nil
+}
+
+// checkCanDebit fails unless money can leave accountID, which any freeze forbids.
+func checkCanDebit(tx *sql.Tx, accountID string) error {
+	deleted, err := checkIfDeleted(tx, accountID)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return fmt.Errorf("%w : account: %s", code.AccountDeleted, accountID)
+	}
+	state, err := checkIfFrozen(tx, accountID)
+	if err != nil {
+		return err
+	}
+	if state != NotFrozen {
+		return fmt.Errorf("%w : account: %s", code.AccountFrozen, accountID)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package account
+
+import (
+	"account-operator/code"
+	"account-operator/market"
+	"account-operator/postgresql"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const defaultTier = "default"
+
+const (
+	LimitWithdrawDaily    = "withdraw_daily"
+	LimitWithdrawWeekly   = "withdraw_weekly"
+	LimitOrderNotional    = "order_notional"
+	LimitOrdersPerMinute  = "orders_per_minute"
+	ordersPerMinuteWindow = time.Minute
+)
+
+// tierLimits are the limits of a user tier, nil or zero values are unlimited.
+type tierLimits struct {
+	// withdrawDaily and withdrawWeekly cap the withdrawals of a user per currency over a rolling window
+	withdrawDaily  map[string]*big.Rat
+	withdrawWeekly map[string]*big.Rat
+	// maxOrderNotional caps the value of a single order in limitsPolicy.notionalCurrency
+	maxOrderNotional   *big.Rat
+	maxOrdersPerMinute int
+}
+
+type limitsPolicy struct {
+	notionalCurrency string
+	tiers            map[string]tierLimits
+}
+
+func loadLimitsPolicy() (limitsPolicy, error) {
+	policy := limitsPolicy{
+		notionalCurrency: viper.GetString("limits.notionalCurrency"),
+		tiers:            make(map[string]tierLimits),
+	}
+	for tier := range viper.GetStringMap("limits.tiers") {
+		prefix := fmt.Sprintf("limits.tiers.%s.", tier)
+		var limits tierLimits
+		var err error
+		limits.withdrawDaily, err = loadCurrencyAmounts(prefix + "withdrawDaily")
+		if err != nil {
+			return limitsPolicy{}, err
+		}
+		limits.withdrawWeekly, err = loadCurrencyAmounts(prefix + "withdrawWeekly")
+		if err != nil {
+			return limitsPolicy{}, err
+		}
+		if notional := viper.GetString(prefix + "maxOrderNotional"); notional != "" {
+			var ok bool
+			limits.maxOrderNotional, ok = new(big.Rat).SetString(notional)
+			if !ok {
+				return limitsPolicy{}, fmt.Errorf("invalid %smaxOrderNotional: %s", prefix, notional)
+			}
+			if policy.notionalCurrency == "" {
+				return limitsPolicy{}, errors.New("limits.notionalCurrency is required by maxOrderNotional")
+			}
+		}
+		limits.maxOrdersPerMinute = viper.GetInt(prefix + "maxOrdersPerMinute")
+		policy.tiers[tier] = limits
+	}
+	return policy, nil
+}
+
+func loadCurrencyAmounts(key string) (map[string]*big.Rat, error) {
+	amounts := make(map[string]*big.Rat)
+	// viper lower-cases keys, currencies are upper case
+	for currency, amount := range viper.GetStringMapString(key) {
+		amountRat, ok := new(big.Rat).SetString(amount)
+		if !ok || amountRat.Sign() < 0 {
+			return nil, fmt.Errorf("invalid %s of %s: %s", key, currency, amount)
+		}
+		amounts[strings.ToUpper(currency)] = amountRat
+	}
+	return amounts, nil
+}
+
+// lockUserLimits returns the limits of userID. It serializes the limited operations of userID
+// until tx ends, so concurrent requests cannot both pass the check against the same allowance.
+func (p limitsPolicy) lockUserLimits(tx *sql.Tx, userID string) (tierLimits, error) {
+	if len(p.tiers) == 0 {
+		return tierLimits{}, nil
+	}
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('limits:' || $1));", userID)
+	if err != nil {
+		return tierLimits{}, fmt.Errorf("failed to lock limits: %w", err)
+	}
+	tier := defaultTier
+	err = tx.QueryRow("SELECT tier FROM user_limit_tier WHERE user_id = $1", userID).Scan(&tier)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return tierLimits{}, fmt.Errorf("failed to get limit tier: %w", err)
+	}
+	limits, ok := p.tiers[strings.ToLower(tier)]
+	if !ok {
+		limits = p.tiers[defaultTier]
+	}
+	return limits, nil
+}
+
+// checkWithdrawLimits fails if withdrawing amount of currency would take userID over a withdrawal cap.
+// Pending withdrawals count, as they are paid out once approved.
+func (p limitsPolicy) checkWithdrawLimits(tx *sql.Tx, userID string, currency string, amount *big.Rat) error {
+	limits, err := p.lockUserLimits(tx, userID)
+	if err != nil {
+		return err
+	}
+	windows := []struct {
+		limit  string
+		caps   map[string]*big.Rat
+		window time.Duration
+	}{
+		{LimitWithdrawDaily, limits.withdrawDaily, 24 * time.Hour},
+		{LimitWithdrawWeekly, limits.withdrawWeekly, 7 * 24 * time.Hour},
+	}
+	for _, w := range windows {
+		maxAmount, ok := w.caps[currency]
+		if !ok {
+			continue
+		}
+		used, err := withdrawnSince(tx, userID, currency, w.window)
+		if err != nil {
+			return err
+		}
+		err = checkAllowance(w.limit, currency, maxAmount, used, amount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func withdrawnSince(tx *sql.Tx, userID string, currency string, window time.Duration) (*big.Rat, error) {
+	query := `
+		SELECT COALESCE(SUM(amount), 0)::TEXT FROM (
+			SELECT -l.amount AS amount
+			FROM deposit_and_withdrawal_log l
+			JOIN account a ON a.id = l.account
+			WHERE a.owner = $1 AND a.currency = $2 AND l.amount < 0 AND l.created_at > NOW() - make_interval(secs => $3)
+			UNION ALL
+			SELECT p.amount
+			FROM pending_withdrawal p
+			JOIN account a ON a.id = p.account
+			WHERE a.owner = $1 AND a.currency = $2 AND p.status = 'pending' AND p.created_at > NOW() - make_interval(secs => $3)
+		) withdrawals;
+	`
+	var used string
+	err := tx.QueryRow(query, userID, currency, window.Seconds()).Scan(&used)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum withdrawals: %w", err)
+	}
+	usedRat, ok := new(big.Rat).SetString(used)
+	if !ok {
+		return nil, fmt.Errorf("invalid withdrawal sum: %s", used)
+	}
+	return usedRat, nil
+}
+
+// checkOrderLimits fails if userID placed too many orders in the last minute, or if the order
+// is worth more than the notional cap. notional is only evaluated when the tier caps it.
+func (p limitsPolicy) checkOrderLimits(tx *sql.Tx, userID string, notional func(currency string) (*big.Rat, error)) error {
+	limits, err := p.lockUserLimits(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	if limits.maxOrdersPerMinute > 0 {
+		// Sweeps of deleted accounts have an empty route and are not orders
+		query := `
+			SELECT COUNT(*)
+			FROM transfer_log t
+			JOIN account a ON a.id = t.from_account
+			WHERE a.owner = $1 AND t.route <> '[]'::JSONB AND t.created_at > NOW() - make_interval(secs => $2);
+		`
+		var orders int
+		err = tx.QueryRow(query, userID, ordersPerMinuteWindow.Seconds()).Scan(&orders)
+		if err != nil {
+			return fmt.Errorf("failed to count orders: %w", err)
+		}
+		if orders >= limits.maxOrdersPerMinute {
+			return fmt.Errorf("failed to trade: %w", code.LimitError{
+				Limit:     LimitOrdersPerMinute,
+				Max:       fmt.Sprint(limits.maxOrdersPerMinute),
+				Remaining: "0",
+			})
+		}
+	}
+
+	if limits.maxOrderNotional != nil {
+		value, err := notional(p.notionalCurrency)
+		if err != nil {
+			return err
+		}
+		err = checkAllowance(LimitOrderNotional, p.notionalCurrency, limits.maxOrderNotional, new(big.Rat), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAllowance fails if used plus amount exceeds maxAmount.
+func checkAllowance(limit string, currency string, maxAmount *big.Rat, used *big.Rat, amount *big.Rat) error {
+	remaining := new(big.Rat).Sub(maxAmount, used)
+	if amount.Cmp(remaining) <= 0 {
+		return nil
+	}
+	if remaining.Sign() < 0 {
+		remaining.SetInt64(0)
+	}
+	return code.LimitError{
+		Limit:     limit,
+		Currency:  currency,
+		Max:       maxAmount.FloatString(8),
+		Remaining: remaining.FloatString(8),
+	}
+}
+
+func (o *operator) SetUserTier(actorID string, userID string, tier string) error {
+	if _, ok := o.limitsPolicy.tiers[strings.ToLower(tier)]; !ok && tier != defaultTier {
+		return fmt.Errorf("%w : unknown tier: %s", code.InvalidRequest, tier)
+	}
+	query := `
+		INSERT INTO user_limit_tier (user_id, tier, actor)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET tier = EXCLUDED.tier, actor = EXCLUDED.actor, updated_at = NOW();
+	`
+	_, err := postgresql.GetClient().Exec(query, userID, strings.ToLower(tier), actorID)
+	if err != nil {
+		return fmt.Errorf("failed to set limit tier: %w", err)
+	}
+	return nil
+}
+
+// convert values amount of currency in targetCurrency at the live market price.
+func (o *operator) convert(amount string, currency string, targetCurrency string) (*big.Rat, error) {
+	rate, err := o.marketInst.Rate(currency, targetCurrency)
+	if errors.Is(err, market.ErrSymbolNotFound) {
+		return nil, fmt.Errorf("%w : no price of %s in %s", code.PriceUnavailable, currency, targetCurrency)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rate of %s in %s: %w", currency, targetCurrency, err)
+	}
+	amountRat, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount: %s", amount)
+	}
+	rateRat, ok := new(big.Rat).SetString(rate.Price)
+	if !ok {
+		return nil, fmt.Errorf("invalid rate: %s", rate.Price)
+	}
+	return amountRat.Mul(amountRat, rateRat), nil
+}
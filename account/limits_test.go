@@ -0,0 +1,71 @@
+package account
+
+import (
+	"account-operator/code"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rat(s string) *big.Rat {
+	r, _ := new(big.Rat).SetString(s)
+	return r
+}
+
+func TestLoadLimitsPolicy(t *testing.T) {
+	viper.Set("limits", map[string]interface{}{
+		"notionalCurrency": "USDT",
+		"tiers": map[string]interface{}{
+			"default": map[string]interface{}{
+				"withdrawDaily":      map[string]string{"BTC": "2"},
+				"withdrawWeekly":     map[string]string{"btc": "10"},
+				"maxOrderNotional":   "100000",
+				"maxOrdersPerMinute": 30,
+			},
+			"vip": map[string]interface{}{
+				"maxOrdersPerMinute": 300,
+			},
+		},
+	})
+	defer viper.Set("limits", nil)
+
+	policy, err := loadLimitsPolicy()
+	require.NoError(t, err)
+	assert.Equal(t, "USDT", policy.notionalCurrency)
+	require.Contains(t, policy.tiers, defaultTier)
+	assert.Equal(t, 0, rat("2").Cmp(policy.tiers[defaultTier].withdrawDaily["BTC"]))
+	assert.Equal(t, 0, rat("10").Cmp(policy.tiers[defaultTier].withdrawWeekly["BTC"]))
+	assert.Equal(t, 0, rat("100000").Cmp(policy.tiers[defaultTier].maxOrderNotional))
+	assert.Equal(t, 30, policy.tiers[defaultTier].maxOrdersPerMinute)
+	assert.Equal(t, 300, policy.tiers["vip"].maxOrdersPerMinute)
+	assert.Nil(t, policy.tiers["vip"].maxOrderNotional)
+}
+
+func TestLoadLimitsPolicyNeedsNotionalCurrency(t *testing.T) {
+	viper.Set("limits.tiers", map[string]interface{}{
+		"default": map[string]interface{}{"maxOrderNotional": "100000"},
+	})
+	defer viper.Set("limits.tiers", nil)
+
+	_, err := loadLimitsPolicy()
+	assert.Error(t, err)
+}
+
+func TestCheckAllowance(t *testing.T) {
+	assert.NoError(t, checkAllowance(LimitWithdrawDaily, "BTC", rat("2"), rat("1.5"), rat("0.5")))
+
+	err := checkAllowance(LimitWithdrawDaily, "BTC", rat("2"), rat("1.5"), rat("0.50000001"))
+	var limitErr code.LimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.ErrorIs(t, err, code.LimitExceeded)
+	assert.Equal(t, "0.50000000", limitErr.Remaining)
+	assert.Equal(t, "2.00000000", limitErr.Max)
+
+	// Withdrawals approved after the cap was lowered can leave it overdrawn
+	err = checkAllowance(LimitWithdrawDaily, "BTC", rat("2"), rat("3"), rat("0.1"))
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, "0.00000000", limitErr.Remaining)
+}
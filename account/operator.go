@@ -3,24 +3,33 @@ package account
 import (
 	"account-operator/code"
 	"account-operator/market"
+	"account-operator/outbox"
 	"account-operator/postgresql"
 	"account-operator/price"
 	"account-operator/protocol"
 	"account-operator/quit"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/rabbitmq/amqp091-go"
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"math/big"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Account interface {
 	ID() string
 	Name() string
 	Currency() string
+	Labels() []string
+	IsDefault() bool
 }
 
 type Operator interface {
@@ -28,56 +37,125 @@ type Operator interface {
 	Close()
 	CreateAccount(userID string, currency string, accountName string) (Account, error)
 	ListAccount(str string) ([]Account, error)
+	// Portfolio values every account of userID in currency at the live market price
+	Portfolio(userID string, currency string) (Portfolio, error)
+	// AccountPnL computes the realized and unrealized PnL of an account of userID in currency,
+	// with the fifo or average cost method
+	AccountPnL(userID string, accountID string, currency string, method string) (PnL, error)
+	UserPnL(userID string, currency string, method string) (UserPnL, error)
+	// Statement streams the movements of an account of userID within [from, to) to w,
+	// with the opening balance and a running balance
+	Statement(ctx context.Context, userID string, accountID string, from time.Time, to time.Time, w StatementWriter) error
 	Deposit(accountID string, amount string) error
-	Withdraw(accountID string, amount string) error
-	DeleteAccount(accountID string) error
-	// MarketOrder Use the currency from fromAccountID to purchase the currency of toAccountID with the amount
-	MarketOrder(req TradeOrderRequest) error
+	// Withdraw withdraws amount from accountID on behalf of actorID, who must own it unless asAdmin.
+	// Withdrawals from the approval threshold of the currency are held instead and returned as pending
+	Withdraw(actorID string, asAdmin bool, accountID string, amount string) (*PendingWithdrawal, error)
+	ListPendingWithdrawals() ([]PendingWithdrawal, error)
+	// ApproveWithdrawal executes a pending withdrawal, actorID must not be its requester
+	ApproveWithdrawal(actorID string, withdrawalID int64, reason string) error
+	// RejectWithdrawal releases the funds held by a pending withdrawal
+	RejectWithdrawal(actorID string, withdrawalID int64, reason string) error
+	// SetUserTier selects the limits.tiers entry whose limits apply to userID
+	SetUserTier(actorID string, userID string, tier string) error
+	// DeleteAccount soft-deletes an account, sweeping a non-zero balance to sweepToAccountID
+	DeleteAccount(actorID string, accountID string, sweepToAccountID string) error
+	RestoreAccount(actorID string, accountID string) error
+	// UpdateAccount renames, relabels or changes the default flag of an account owned by userID
+	UpdateAccount(userID string, accountID string, req UpdateAccountRequest) (Account, error)
+	// FreezeAccount stops withdrawals and trades of an account, and deposits too for the full state
+	FreezeAccount(actorID string, accountID string, state string, reason string) error
+	UnfreezeAccount(actorID string, accountID string, reason string) error
+	// MarketOrder Use the currency from fromAccountID to purchase the currency of toAccountID with the amount.
+	// Both accounts must be owned by actorID unless asAdmin
+	MarketOrder(actorID string, asAdmin bool, req TradeOrderRequest) error
+	// SubscribeSymbol starts receiving prices of symbol without a restart
+	SubscribeSymbol(symbol string) error
+	// UnsubscribeSymbol stops receiving prices of symbol and removes it from the market
+	UnsubscribeSymbol(symbol string) error
+	ListSymbols() []string
 }
 
-func NewOperator(msgs price.Delivers, marketInst market.Market) Operator {
-	return &operator{
-		marketInst:    marketInst,
-		priceDelivers: msgs,
-		stop:          make(chan struct{}, 1),
+func NewOperator(source price.Source, feeds price.Feeds, marketInst market.Market) (Operator, error) {
+	policy, err := loadWithdrawalPolicy()
+	if err != nil {
+		return nil, err
+	}
+	limits, err := loadLimitsPolicy()
+	if err != nil {
+		return nil, err
 	}
+	return &operator{
+		marketInst:       marketInst,
+		priceSource:      source,
+		priceFeeds:       feeds,
+		symbolRunners:    make(map[string]symbolRunner),
+		withdrawalPolicy: policy,
+		limitsPolicy:     limits,
+		valueCurrency:    viper.GetString("pnl.valueCurrency"),
+		stop:             make(chan struct{}, 1),
+	}, nil
 }
 
 type operator struct {
-	marketInst    market.Market
-	priceDelivers price.Delivers
+	marketInst  market.Market
+	priceSource price.Source
+	priceFeeds  price.Feeds
+	// symbol -> goroutine consuming its prices
+	symbolRunners     map[string]symbolRunner
+	symbolRunnersLock sync.Mutex
+	withdrawalPolicy  withdrawalPolicy
+	limitsPolicy      limitsPolicy
+	// valueCurrency is the currency trades are valued in when they settle, for PnL
+	valueCurrency string
 	stop          chan struct{}
 }
 
+type symbolRunner struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// TradeOrderRequest trades Quantity of the base currency of Symbol. The two accounts may be given
+// in either order, they are matched to the base and quote currency of Symbol. When only the reverse
+// of Symbol is quoted, the trade is recorded on that symbol with the opposite side.
 type TradeOrderRequest struct {
 	BaseCurrencyAccount  string `json:"base_account_id" binding:"required"`
 	QuoteCurrencyAccount string `json:"quote_account_id" binding:"required"`
 	Symbol               string `json:"symbol" binding:"required"`
-	Side                 string `json:"side" binding:"required"`
-	Type                 string `json:"type" binding:"required"`
-	Quantity             string `json:"quantity" binding:"required"`
+	// Side is buy or sell, relative to the base currency of Symbol
+	Side     string `json:"side" binding:"required"`
+	Type     string `json:"type" binding:"required"`
+	Quantity string `json:"quantity" binding:"required"`
 	// could be ignored for market order
 	Price string `json:"price"`
 }
 
-func (o *operator) MarketOrder(req TradeOrderRequest) error {
-	dbClient := postgresql.GetClient()
-	getCurrencyQuery := "SELECT currency FROM account WHERE id = $1"
-	var baseCurrency string
-	err := dbClient.QueryRow(getCurrencyQuery, req.BaseCurrencyAccount).Scan(&baseCurrency)
+func (o *operator) MarketOrder(actorID string, asAdmin bool, req TradeOrderRequest) error {
+	baseCurrency, err := getTradedCurrency(req.BaseCurrencyAccount, actorID, asAdmin)
 	if err != nil {
-		return fmt.Errorf("failed to get fromAccountID currency: %w", err)
+		return err
 	}
-	var quoteCurrency string
-	err = dbClient.QueryRow(getCurrencyQuery, req.QuoteCurrencyAccount).Scan(&quoteCurrency)
+	quoteCurrency, err := getTradedCurrency(req.QuoteCurrencyAccount, actorID, asAdmin)
 	if err != nil {
-		return fmt.Errorf("failed to get toAccountID currency: %w", err)
+		return err
 	}
 
 	symbol := req.Symbol
-	shouldBeSymbol := fmt.Sprintf("%s%s", baseCurrency, quoteCurrency)
-	if symbol != shouldBeSymbol {
-		return fmt.Errorf("account currency mismatch: %s != %s", symbol, shouldBeSymbol)
+	baseAccountID, quoteAccountID := req.BaseCurrencyAccount, req.QuoteCurrencyAccount
+	switch symbol {
+	case baseCurrency + quoteCurrency:
+	case quoteCurrency + baseCurrency:
+		// The accounts were given the other way around
+		baseAccountID, quoteAccountID = quoteAccountID, baseAccountID
+		baseCurrency, quoteCurrency = quoteCurrency, baseCurrency
+	default:
+		return fmt.Errorf("%w : account currency mismatch: %s is neither %s%s nor %s%s", code.InvalidRequest, symbol, baseCurrency, quoteCurrency, quoteCurrency, baseCurrency)
+	}
+	if baseCurrency == quoteCurrency {
+		return fmt.Errorf("%w : both accounts hold %s", code.InvalidRequest, baseCurrency)
+	}
+	if req.Side != "buy" && req.Side != "sell" {
+		return fmt.Errorf("%w : invalid side: %s", code.InvalidRequest, req.Side)
 	}
 
 	switch req.Type {
@@ -86,7 +164,18 @@ func (o *operator) MarketOrder(req TradeOrderRequest) error {
 		if err != nil {
 			return fmt.Errorf("quantity should be a valid numeric value: %w", err)
 		}
-		err = o.marketInst.MarketOrder(symbol, o.marketOrderCallBack(req.BaseCurrencyAccount, req.QuoteCurrencyAccount, req.Quantity, req.Side))
+		// When only the reverse pair is quoted, the order is placed on it with the opposite side
+		err = o.marketInst.MarketOrder(baseCurrency, quoteCurrency, o.marketOrderCallBack(actorID, marketOrder{
+			baseAccountID:  baseAccountID,
+			quoteAccountID: quoteAccountID,
+			baseCurrency:   baseCurrency,
+			quoteCurrency:  quoteCurrency,
+			side:           req.Side,
+			quantity:       req.Quantity,
+		}))
+		if errors.Is(err, market.ErrSymbolNotFound) {
+			return fmt.Errorf("%w : symbol: %s", code.PriceUnavailable, symbol)
+		}
 		if err != nil {
 			return err
 		}
@@ -99,64 +188,145 @@ func (o *operator) MarketOrder(req TradeOrderRequest) error {
 	}
 }
 
-func (o *operator) marketOrderCallBack(baseCurrencyAccountID string, quoteCurrencyAccountID string, quantity string, side string) func(price string) {
-	return func(price string) {
-		var priceBig big.Float
-		priceBig.SetString(price)
-		var quantityBig big.Float
-		quantityBig.SetString(quantity)
-		var amountBig big.Float
-		amountBig.Mul(&priceBig, &quantityBig)
+// getTradedCurrency returns the currency of an account traded by actorID.
+func getTradedCurrency(accountID string, actorID string, asAdmin bool) (string, error) {
+	var owner, currency string
+	err := postgresql.GetClient().QueryRow("SELECT owner, currency FROM account WHERE id = $1", accountID).Scan(&owner, &currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%w : account: %s", code.AccountNotFound, accountID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get account currency: %w", err)
+	}
+	err = checkOwner(accountID, owner, actorID, asAdmin)
+	if err != nil {
+		return "", err
+	}
+	return currency, nil
+}
 
-		switch side {
-		case "buy":
-			var exchangeRate big.Float
-			exchangeRate.Quo(&amountBig, &quantityBig)
-			dbClient := postgresql.GetClient()
-			tx, err := dbClient.Begin()
-			if err != nil {
-				logrus.Errorf("failed to start transaction: %s", err)
-				return
-			}
-			defer tx.Rollback()
+// checkOwner fails unless actorID owns accountID or acts as an admin. Accounts of other users
+// are reported as not found, so that their ids cannot be probed.
+func checkOwner(accountID string, owner string, actorID string, asAdmin bool) error {
+	if asAdmin || owner == actorID {
+		return nil
+	}
+	return fmt.Errorf("%w : account: %s", code.AccountNotFound, accountID)
+}
 
-			transferLogQuery := "INSERT INTO transfer_log (from_account, to_account, exchange_rate, from_amount , to_amount) VALUES ($1, $2, $3, $4, $5);"
-			_, err = tx.Exec(transferLogQuery, quoteCurrencyAccountID, baseCurrencyAccountID, exchangeRate.String(), amountBig.String(), quantityBig.String())
-			if err != nil {
-				logrus.Errorf("failed to log transfer: %s", err)
-				return
-			}
+// marketOrder trades quantity of baseCurrency against quoteCurrency, side is relative to baseCurrency.
+type marketOrder struct {
+	baseAccountID  string
+	quoteAccountID string
+	baseCurrency   string
+	quoteCurrency  string
+	side           string
+	quantity       string
+}
 
-			updateAccountQuery := "UPDATE account SET balance = balance + $1 WHERE id = $2;"
-			_, err = tx.Exec(updateAccountQuery, quantity, baseCurrencyAccountID)
-			if err != nil {
-				logrus.Errorf("failed to update account: %s", err)
-				return
-			}
+// settlement is a market order priced on the quoted symbol, with its amounts rounded.
+type settlement struct {
+	baseAccountID  string
+	quoteAccountID string
+	quoteCurrency  string
+	side           string
+	exchangeRate   string
+	baseAmount     string
+	quoteAmount    string
+	route          []market.Leg
+}
 
-			_, err = tx.Exec(updateAccountQuery, fmt.Sprintf("-%s", amountBig.String()), quoteCurrencyAccountID)
-			if err != nil {
-				logrus.Errorf("failed to update account: %s", err)
-				return
-			}
+// settle prices order at rate. When only the reverse symbol is quoted, the order is placed on that
+// symbol with the opposite side, so that its own price is recorded, and quantity is then in its
+// quote currency. Only the settled amounts are rounded, the rate keeps its precision.
+func (order marketOrder) settle(rate market.Rate) (settlement, error) {
+	quantity, ok := new(big.Rat).SetString(order.quantity)
+	if !ok {
+		return settlement{}, fmt.Errorf("%w : invalid quantity: %s", code.InvalidRequest, order.quantity)
+	}
+
+	if len(rate.Route) == 1 && rate.Route[0].Inverse {
+		leg := rate.Route[0]
+		legPrice, ok := new(big.Rat).SetString(leg.Price)
+		if !ok || legPrice.Sign() <= 0 {
+			return settlement{}, fmt.Errorf("invalid price of %s: %s", leg.Symbol, leg.Price)
+		}
+		side := "buy"
+		if order.side == "buy" {
+			side = "sell"
+		}
+		return settlement{
+			baseAccountID:  order.quoteAccountID,
+			quoteAccountID: order.baseAccountID,
+			quoteCurrency:  order.baseCurrency,
+			side:           side,
+			exchangeRate:   leg.Price,
+			baseAmount:     new(big.Rat).Quo(quantity, legPrice).FloatString(8),
+			quoteAmount:    quantity.FloatString(8),
+			route:          []market.Leg{{Symbol: leg.Symbol, Price: leg.Price}},
+		}, nil
+	}
+
+	price, ok := new(big.Rat).SetString(rate.Price)
+	if !ok {
+		return settlement{}, fmt.Errorf("invalid rate: %s", rate.Price)
+	}
+	return settlement{
+		baseAccountID:  order.baseAccountID,
+		quoteAccountID: order.quoteAccountID,
+		quoteCurrency:  order.quoteCurrency,
+		side:           order.side,
+		exchangeRate:   rate.Price,
+		baseAmount:     quantity.FloatString(8),
+		quoteAmount:    new(big.Rat).Mul(quantity, price).FloatString(8),
+		route:          rate.Route,
+	}, nil
+}
+
+func (o *operator) marketOrderCallBack(actorID string, order marketOrder) func(rate market.Rate) error {
+	return func(rate market.Rate) error {
+		s, err := order.settle(rate)
+		if err != nil {
+			return err
+		}
+
+		route, err := json.Marshal(s.route)
+		if err != nil {
+			return fmt.Errorf("failed to marshal route: %w", err)
+		}
 
-			err = tx.Commit()
-			if err != nil {
-				logrus.Errorf("failed to commit transaction: %s", err)
-				return
+		// The order is worth its quote amount, converted for the notional limit
+		notional := func(currency string) (*big.Rat, error) {
+			return o.convert(s.quoteAmount, s.quoteCurrency, currency)
+		}
+
+		// PnL values the trade at the rates it settled at, unless there was none
+		var value *tradeValue
+		if o.valueCurrency != "" {
+			valueRat, valueErr := notional(o.valueCurrency)
+			if valueErr == nil {
+				value = &tradeValue{currency: o.valueCurrency, amount: valueRat.FloatString(8)}
+			} else if !errors.Is(valueErr, code.PriceUnavailable) {
+				return valueErr
 			}
+		}
+
+		switch s.side {
+		case "buy":
+			return o.settleTrade(actorID, s.quoteAccountID, s.baseAccountID, s.exchangeRate, s.quoteAmount, s.baseAmount, route, value, notional)
 		case "sell":
-			panic("not implemented")
+			return o.settleTrade(actorID, s.baseAccountID, s.quoteAccountID, s.exchangeRate, s.baseAmount, s.quoteAmount, route, value, notional)
+		default:
+			return fmt.Errorf("%w : invalid side: %s", code.InvalidRequest, s.side)
 		}
 	}
 }
 
-func (o *operator) Withdraw(accountID string, amount string) error {
-	err := isValidAmount(amount)
-	if err != nil {
-		return fmt.Errorf("failed to withdraw: %w", err)
-	}
-
+// settleTrade moves fromAmount out of fromAccountID and toAmount into toAccountID in a single transaction.
+// exchangeRate is the price of the traded symbol, and route records the quoted symbols it was derived
+// from, so a cross-rate conversion settles both legs at once. The order limits of actorID, who
+// placed the order, are checked within the same transaction.
+func (o *operator) settleTrade(actorID string, fromAccountID string, toAccountID string, exchangeRate string, fromAmount string, toAmount string, route []byte, value *tradeValue, notional func(currency string) (*big.Rat, error)) error {
 	dbClient := postgresql.GetClient()
 
 	// Start a transaction
@@ -166,46 +336,68 @@ func (o *operator) Withdraw(accountID string, amount string) error {
 	}
 	defer tx.Rollback()
 
-	deleted, err := checkIfDeleted(tx, accountID)
+	// Frozen accounts cannot trade, in either direction
+	for _, accountID := range []string{fromAccountID, toAccountID} {
+		err = checkCanDebit(tx, accountID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = o.limitsPolicy.checkOrderLimits(tx, actorID, notional)
 	if err != nil {
 		return err
 	}
-	if deleted {
-		return fmt.Errorf("%w : account: %s", code.AccountDeleted, accountID)
+
+	err = writeTransfer(tx, fromAccountID, toAccountID, exchangeRate, fromAmount, toAmount, route, value)
+	if err != nil {
+		return err
 	}
 
-	// Prepare the SQL statement to insert a log entry
-	logQuery := `
-		INSERT INTO deposit_and_withdrawal_log (account, amount)
-		VALUES ($1, $2);
-	`
+	// Commit the transaction
+	if commitErr := tx.Commit(); commitErr != nil {
+		return fmt.Errorf("failed to commit transaction: %w", commitErr)
+	}
+	return nil
+}
 
-	// Execute the SQL statement to insert a log entry
-	_, err = tx.Exec(logQuery, accountID, fmt.Sprintf("-%s", amount))
+// tradeValue is the worth of a trade in currency when it settled.
+type tradeValue struct {
+	currency string
+	amount   string
+}
+
+// writeTransfer logs a transfer and applies it to both balances within tx. value may be nil.
+func writeTransfer(tx *sql.Tx, fromAccountID string, toAccountID string, exchangeRate string, fromAmount string, toAmount string, route []byte, value *tradeValue) error {
+	var valueCurrency, valueAmount sql.NullString
+	if value != nil {
+		valueCurrency = sql.NullString{String: value.currency, Valid: true}
+		valueAmount = sql.NullString{String: value.amount, Valid: true}
+	}
+	transferLogQuery := "INSERT INTO transfer_log (from_account, to_account, exchange_rate, from_amount, to_amount, route, value_currency, value) VALUES ($1, $2, $3, $4, $5, $6, $7, $8);"
+	_, err := tx.Exec(transferLogQuery, fromAccountID, toAccountID, exchangeRate, fromAmount, toAmount, route, valueCurrency, valueAmount)
 	if err != nil {
-		return fmt.Errorf("failed to log withdrawal: %w", err)
+		return fmt.Errorf("failed to log transfer: %w", err)
 	}
 
-	// Prepare the SQL statement to update the account balance
-	updateQuery := `
-		UPDATE account
-		SET balance = balance - $1
-		WHERE id = $2;
-	`
-
-	// Execute the SQL statement to update the account balance
-	_, err = tx.Exec(updateQuery, amount, accountID)
+	updateAccountQuery := "UPDATE account SET balance = balance + $1 WHERE id = $2;"
+	_, err = tx.Exec(updateAccountQuery, toAmount, toAccountID)
 	if err != nil {
-		return fmt.Errorf("failed to withdraw: %w", err)
+		return fmt.Errorf("failed to update account: %w", err)
 	}
 
-	// Commit the transaction
-	if commitErr := tx.Commit(); commitErr != nil {
-		return fmt.Errorf("failed to commit transaction: %w", commitErr)
+	_, err = tx.Exec(updateAccountQuery, fmt.Sprintf("-%s", fromAmount), fromAccountID)
+	if err != nil {
+		return fmt.Errorf("failed to update account: %w", err)
 	}
 
-	// Return nil
-	return nil
+	return outbox.Write(tx, protocol.TradeEvent, protocol.TradeEventBody{
+		FromAccountID: fromAccountID,
+		ToAccountID:   toAccountID,
+		ExchangeRate:  exchangeRate,
+		FromAmount:    fromAmount,
+		ToAmount:      toAmount,
+	})
 }
 
 func (o *operator) Deposit(accountID string, amount string) error {
@@ -231,6 +423,15 @@ func (o *operator) Deposit(accountID string, amount string) error {
 		return fmt.Errorf("%w : account: %s", code.AccountDeleted, accountID)
 	}
 
+	// Debit-frozen accounts can still receive deposits
+	freezeState, err := checkIfFrozen(tx, accountID)
+	if err != nil {
+		return err
+	}
+	if freezeState == FullyFrozen {
+		return fmt.Errorf("%w : account: %s", code.AccountFrozen, accountID)
+	}
+
 	// Prepare the SQL statement to insert a log entry
 	logQuery := `
 		INSERT INTO deposit_and_withdrawal_log (account, amount)
@@ -256,6 +457,12 @@ func (o *operator) Deposit(accountID string, amount string) error {
 		return fmt.Errorf("failed to deposit: %w", err)
 	}
 
+	// Publish the deposit event together with the balance change
+	err = outbox.Write(tx, protocol.DepositEvent, protocol.AccountEventBody{AccountID: accountID, Amount: amount})
+	if err != nil {
+		return err
+	}
+
 	// Commit the transaction
 	if commitErr := tx.Commit(); commitErr != nil {
 		return fmt.Errorf("failed to commit transaction: %w", commitErr)
@@ -265,7 +472,9 @@ func (o *operator) Deposit(accountID string, amount string) error {
 	return nil
 }
 
-func (o *operator) DeleteAccount(accountID string) error {
+// DeleteAccount soft-deletes an empty account. A non-empty account is only deleted when
+// sweepToAccountID names another account of the same owner and currency to move its balance to.
+func (o *operator) DeleteAccount(actorID string, accountID string, sweepToAccountID string) error {
 	dbClient := postgresql.GetClient()
 
 	// Start a transaction
@@ -275,10 +484,55 @@ func (o *operator) DeleteAccount(accountID string) error {
 	}
 	defer tx.Rollback()
 
+	// Lock the account so that its balance cannot change until it is deleted
+	var owner, currency, balance string
+	var isDeleted, hasHeldFunds bool
+	err = tx.QueryRow("SELECT owner, currency, balance, is_deleted, held_balance <> 0 FROM account WHERE id = $1 FOR UPDATE", accountID).Scan(&owner, &currency, &balance, &isDeleted, &hasHeldFunds)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w : account: %s", code.AccountNotFound, accountID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get account: %w", err)
+	}
+	if isDeleted {
+		return fmt.Errorf("%w : account: %s", code.AccountDeleted, accountID)
+	}
+	err = checkCanDebit(tx, accountID)
+	if err != nil {
+		return err
+	}
+	if hasHeldFunds {
+		return fmt.Errorf("%w : account: %s has pending withdrawals", code.AccountNotEmpty, accountID)
+	}
+
+	balanceRat, ok := new(big.Rat).SetString(balance)
+	if !ok {
+		return fmt.Errorf("invalid balance: %s", balance)
+	}
+	sweptAmount := "0"
+	switch balanceRat.Sign() {
+	case 0:
+	case -1:
+		return fmt.Errorf("%w : account: %s has a negative balance", code.AccountNotEmpty, accountID)
+	default:
+		if sweepToAccountID == "" {
+			return fmt.Errorf("%w : account: %s, sweep its balance to another account", code.AccountNotEmpty, accountID)
+		}
+		err = checkSweepTarget(tx, sweepToAccountID, accountID, owner, currency)
+		if err != nil {
+			return err
+		}
+		sweptAmount = balanceRat.FloatString(8)
+		err = writeTransfer(tx, accountID, sweepToAccountID, "1", sweptAmount, sweptAmount, []byte("[]"), nil)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Prepare the SQL statement to mark the account as deleted
 	deleteQuery := `
 		UPDATE account
-		SET is_deleted = TRUE
+		SET is_deleted = TRUE, is_default = FALSE
 		WHERE id = $1;
 	`
 
@@ -288,6 +542,16 @@ func (o *operator) DeleteAccount(accountID string) error {
 		return fmt.Errorf("failed to delete account: %w", err)
 	}
 
+	err = logDeletion(tx, accountID, "delete", actorID, sweepToAccountID, sweptAmount)
+	if err != nil {
+		return err
+	}
+
+	err = outbox.Write(tx, protocol.AccountDeletedEvent, protocol.AccountEventBody{AccountID: accountID})
+	if err != nil {
+		return err
+	}
+
 	// Commit the transaction
 	if commitErr := tx.Commit(); commitErr != nil {
 		return fmt.Errorf("failed to commit transaction: %w", commitErr)
@@ -297,6 +561,95 @@ func (o *operator) DeleteAccount(accountID string) error {
 	return nil
 }
 
+// checkSweepTarget fails unless targetID can receive the balance of a deleted account.
+func checkSweepTarget(tx *sql.Tx, targetID string, accountID string, owner string, currency string) error {
+	if targetID == accountID {
+		return fmt.Errorf("%w : cannot sweep an account into itself", code.InvalidRequest)
+	}
+	var targetOwner, targetCurrency, targetFreezeState string
+	var targetDeleted bool
+	err := tx.QueryRow("SELECT owner, currency, is_deleted, freeze_state FROM account WHERE id = $1", targetID).Scan(&targetOwner, &targetCurrency, &targetDeleted, &targetFreezeState)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w : account: %s", code.AccountNotFound, targetID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get sweep account: %w", err)
+	}
+	if targetDeleted {
+		return fmt.Errorf("%w : account: %s", code.AccountDeleted, targetID)
+	}
+	if targetFreezeState == FullyFrozen {
+		return fmt.Errorf("%w : account: %s", code.AccountFrozen, targetID)
+	}
+	if targetOwner != owner || targetCurrency != currency {
+		return fmt.Errorf("%w : sweep account %s must have the same owner and currency", code.InvalidRequest, targetID)
+	}
+	return nil
+}
+
+// RestoreAccount undoes the soft deletion of an account.
+func (o *operator) RestoreAccount(actorID string, accountID string) error {
+	dbClient := postgresql.GetClient()
+
+	// Start a transaction
+	tx, err := dbClient.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var owner, name string
+	var isDeleted bool
+	err = tx.QueryRow("SELECT owner, name, is_deleted FROM account WHERE id = $1 FOR UPDATE", accountID).Scan(&owner, &name, &isDeleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w : account: %s", code.AccountNotFound, accountID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get account: %w", err)
+	}
+	if !isDeleted {
+		return fmt.Errorf("%w : account: %s", code.AccountNotDeleted, accountID)
+	}
+	// Another account may have taken the name in the meantime
+	err = checkNameAvailable(tx, owner, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE account SET is_deleted = FALSE WHERE id = $1;", accountID)
+	if err != nil {
+		return fmt.Errorf("failed to restore account: %w", err)
+	}
+
+	err = logDeletion(tx, accountID, "restore", actorID, "", "0")
+	if err != nil {
+		return err
+	}
+
+	err = outbox.Write(tx, protocol.AccountRestoredEvent, protocol.AccountEventBody{AccountID: accountID})
+	if err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if commitErr := tx.Commit(); commitErr != nil {
+		return fmt.Errorf("failed to commit transaction: %w", commitErr)
+	}
+	return nil
+}
+
+func logDeletion(tx *sql.Tx, accountID string, action string, actorID string, sweepToAccountID string, sweptAmount string) error {
+	logQuery := `
+		INSERT INTO account_deletion_log (account, action, actor, sweep_to, swept_amount)
+		VALUES ($1, $2, $3, $4, $5);
+	`
+	_, err := tx.Exec(logQuery, accountID, action, actorID, sweepToAccountID, sweptAmount)
+	if err != nil {
+		return fmt.Errorf("failed to log account %s: %w", action, err)
+	}
+	return nil
+}
+
 func (o *operator) ListAccount(str string) ([]Account, error) {
 	dbClient := postgresql.GetClient()
 
@@ -309,7 +662,7 @@ func (o *operator) ListAccount(str string) ([]Account, error) {
 
 	// Prepare the SQL statement
 	query := `
-		SELECT id, currency, name
+		SELECT id, currency, name, labels, is_default
 		FROM account
 		WHERE owner = (SELECT id FROM public.users WHERE id = $1) AND is_deleted = FALSE;
 	`
@@ -325,7 +678,7 @@ func (o *operator) ListAccount(str string) ([]Account, error) {
 	var accountInstSlice []Account
 	for rows.Next() {
 		var accountInst account
-		err := rows.Scan(&accountInst.id, &accountInst.currency, &accountInst.name)
+		err := rows.Scan(&accountInst.id, &accountInst.currency, &accountInst.name, pq.Array(&accountInst.labels), &accountInst.isDefault)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan account: %w", err)
 		}
@@ -361,6 +714,11 @@ func (o *operator) CreateAccount(userID string, currency string, accountName str
 		return nil, fmt.Errorf("%w : currency: %s", code.CurrencyNotFound, currency)
 	}
 
+	err = checkNameAvailable(tx, userID, accountName)
+	if err != nil {
+		return nil, err
+	}
+
 	// Prepare the SQL statement
 	query := `
 		INSERT INTO account (currency, name, owner)
@@ -375,6 +733,11 @@ func (o *operator) CreateAccount(userID string, currency string, accountName str
 		return nil, fmt.Errorf("failed to create account: %w", err)
 	}
 
+	err = outbox.Write(tx, protocol.AccountCreatedEvent, protocol.AccountEventBody{AccountID: accountInst.id, Currency: accountInst.currency})
+	if err != nil {
+		return nil, err
+	}
+
 	// Commit the transaction
 	if commitErr := tx.Commit(); commitErr != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", commitErr)
@@ -385,43 +748,136 @@ func (o *operator) CreateAccount(userID string, currency string, accountName str
 }
 
 func (o *operator) Start() {
-	for symbol, delivery := range o.priceDelivers {
-		g := quit.ReportGoroutine(fmt.Sprintf("operator for symbol %s", symbol))
-		go func(g quit.Goroutine) {
-			defer g.Done()
-			o.run(symbol, delivery)
-		}(g)
+	o.symbolRunnersLock.Lock()
+	defer o.symbolRunnersLock.Unlock()
+	for symbol, feed := range o.priceFeeds {
+		o.startSymbol(symbol, feed)
 	}
+	o.runWithdrawalExpiry()
 	return
 }
 
-func (o *operator) run(symbol string, delivery <-chan amqp091.Delivery) {
+// startSymbol must be called with symbolRunnersLock held.
+func (o *operator) startSymbol(symbol string, feed <-chan price.Tick) {
+	runner := symbolRunner{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	o.symbolRunners[symbol] = runner
+	g := quit.ReportGoroutine(fmt.Sprintf("operator for symbol %s", symbol))
+	go func(g quit.Goroutine) {
+		defer g.Done()
+		feedClosed := o.run(symbol, feed, runner.stop)
+		// Closed before taking the lock, which UnsubscribeSymbol holds while waiting for it
+		close(runner.done)
+		if feedClosed {
+			o.forgetSymbol(symbol, runner)
+		}
+	}(g)
+}
+
+// forgetSymbol drops the runner of a symbol whose feed closed by itself, so that the symbol
+// is no longer listed and can be subscribed again.
+func (o *operator) forgetSymbol(symbol string, runner symbolRunner) {
+	o.symbolRunnersLock.Lock()
+	defer o.symbolRunnersLock.Unlock()
+	if current, exists := o.symbolRunners[symbol]; !exists || current.done != runner.done {
+		// Unsubscribed meanwhile
+		return
+	}
+	delete(o.symbolRunners, symbol)
+	o.marketInst.RemoveSymbol(symbol)
+	err := o.priceSource.Unsubscribe(symbol)
+	if err != nil && !errors.Is(err, price.ErrSymbolNotSubscribed) {
+		logrus.Errorf("Failed to unsubscribe symbol %s after its feed closed: %s", symbol, err)
+	}
+}
+
+var symbolPattern = regexp.MustCompile(`^[A-Z0-9]+$`)
+
+func (o *operator) SubscribeSymbol(symbol string) error {
+	if !symbolPattern.MatchString(symbol) {
+		return fmt.Errorf("%w : symbol must be upper-case letters and digits: %q", code.InvalidRequest, symbol)
+	}
+
+	o.symbolRunnersLock.Lock()
+	defer o.symbolRunnersLock.Unlock()
+	if _, exists := o.symbolRunners[symbol]; exists {
+		return fmt.Errorf("%w : symbol: %s", code.SymbolAlreadySubscribed, symbol)
+	}
+
+	feed, err := o.priceSource.Subscribe(symbol)
+	if err != nil {
+		if errors.Is(err, price.ErrSymbolAlreadySubscribed) {
+			return fmt.Errorf("%w : symbol: %s", code.SymbolAlreadySubscribed, symbol)
+		}
+		return fmt.Errorf("failed to subscribe symbol %s: %w", symbol, err)
+	}
+	o.startSymbol(symbol, feed)
+	logrus.Infof("Subscribed symbol %s", symbol)
+	return nil
+}
+
+func (o *operator) UnsubscribeSymbol(symbol string) error {
+	o.symbolRunnersLock.Lock()
+	defer o.symbolRunnersLock.Unlock()
+	runner, exists := o.symbolRunners[symbol]
+	if !exists {
+		return fmt.Errorf("%w : symbol: %s", code.SymbolNotSubscribed, symbol)
+	}
+	close(runner.stop)
+	delete(o.symbolRunners, symbol)
+	// Wait for the last message in flight so it does not add the symbol back to the market
+	<-runner.done
+	o.marketInst.RemoveSymbol(symbol)
+
+	err := o.priceSource.Unsubscribe(symbol)
+	if err != nil && !errors.Is(err, price.ErrSymbolNotSubscribed) {
+		return fmt.Errorf("failed to unsubscribe symbol %s: %w", symbol, err)
+	}
+	logrus.Infof("Unsubscribed symbol %s", symbol)
+	return nil
+}
+
+func (o *operator) ListSymbols() []string {
+	o.symbolRunnersLock.Lock()
+	defer o.symbolRunnersLock.Unlock()
+	symbols := make([]string, 0, len(o.symbolRunners))
+	for symbol := range o.symbolRunners {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// run applies the ticks of feed to the market until it is stopped. It reports whether it returned
+// because feed was closed.
+func (o *operator) run(symbol string, feed <-chan price.Tick, symbolStop <-chan struct{}) (feedClosed bool) {
 	for {
 		select {
 		case <-o.stop:
 			logrus.Infof("Stopping operator for symbol %s", symbol)
-			return
-		case msg, ok := <-delivery:
+			return false
+		case <-symbolStop:
+			logrus.Infof("Stopping operator for symbol %s because it is unsubscribed", symbol)
+			return false
+		case tick, ok := <-feed:
 			if !ok {
-				logrus.Infof("Stopping operator for symbol %s because delivery channel is closed", symbol)
-				return
+				logrus.Infof("Stopping operator for symbol %s because price feed is closed", symbol)
+				return true
 			}
-			o.newCoinPriceMessage(msg.Body)
+			o.marketInst.UpdatePrice(tick.Symbol, market.Quote{
+				Source:    tick.Source,
+				Price:     tick.Price,
+				Quantity:  tick.Quantity,
+				TradeID:   tick.TradeID,
+				TradeTime: tick.TradeTime,
+			})
+			tick.Ack()
 		}
 	}
 }
 
-func (o *operator) newCoinPriceMessage(body []byte) {
-	var coinPriceBody protocol.CoinPriceBody
-	err := json.Unmarshal(body, &coinPriceBody)
-	if err != nil {
-		logrus.Errorf("Failed to unmarshal event: %s", err)
-		return
-	}
-
-	o.marketInst.UpdatePrice(coinPriceBody.WsTradeEvent.Symbol, coinPriceBody.WsTradeEvent.Price)
-}
-
 func (o *operator) Close() {
 	close(o.stop)
 }
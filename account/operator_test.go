@@ -0,0 +1,182 @@
+package account
+
+import (
+	"account-operator/code"
+	"account-operator/market"
+	"account-operator/price"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarketOrderSettle(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    marketOrder
+		rate     market.Rate
+		expected settlement
+	}{
+		{
+			name:  "QuotedSymbol",
+			order: marketOrder{baseAccountID: "btc", quoteAccountID: "usdt", baseCurrency: "BTC", quoteCurrency: "USDT", side: "buy", quantity: "0.5"},
+			rate:  market.Rate{Price: "63000", Route: []market.Leg{{Symbol: "BTCUSDT", Price: "63000"}}},
+			expected: settlement{
+				baseAccountID: "btc", quoteAccountID: "usdt", quoteCurrency: "USDT", side: "buy",
+				exchangeRate: "63000", baseAmount: "0.50000000", quoteAmount: "31500.00000000",
+				route: []market.Leg{{Symbol: "BTCUSDT", Price: "63000"}},
+			},
+		},
+		{
+			name:  "ReverseSymbol",
+			order: marketOrder{baseAccountID: "usdt", quoteAccountID: "btc", baseCurrency: "USDT", quoteCurrency: "BTC", side: "buy", quantity: "100"},
+			rate:  market.Rate{Price: "0.000015873015873015873016", Route: []market.Leg{{Symbol: "BTCUSDT", Price: "63000", Inverse: true}}},
+			expected: settlement{
+				baseAccountID: "btc", quoteAccountID: "usdt", quoteCurrency: "USDT", side: "sell",
+				exchangeRate: "63000", baseAmount: "0.00158730", quoteAmount: "100.00000000",
+				route: []market.Leg{{Symbol: "BTCUSDT", Price: "63000"}},
+			},
+		},
+		{
+			name:  "Cross",
+			order: marketOrder{baseAccountID: "shib", quoteAccountID: "eur", baseCurrency: "SHIB", quoteCurrency: "EUR", side: "sell", quantity: "123456789"},
+			rate:  market.Rate{Price: "0.000011106", Route: []market.Leg{{Symbol: "SHIBUSDT", Price: "0.00001234"}, {Symbol: "USDTEUR", Price: "0.9"}}},
+			expected: settlement{
+				baseAccountID: "shib", quoteAccountID: "eur", quoteCurrency: "EUR", side: "sell",
+				exchangeRate: "0.000011106", baseAmount: "123456789.00000000", quoteAmount: "1371.11109863",
+				route: []market.Leg{{Symbol: "SHIBUSDT", Price: "0.00001234"}, {Symbol: "USDTEUR", Price: "0.9"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := tt.order.settle(tt.rate)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, s)
+		})
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	tests := []struct {
+		name    string
+		owner   string
+		actorID string
+		asAdmin bool
+		wantErr error
+	}{
+		{name: "Owner", owner: "alice", actorID: "alice"},
+		{name: "OtherUser", owner: "alice", actorID: "mallory", wantErr: code.AccountNotFound},
+		{name: "Admin", owner: "alice", actorID: "admin", asAdmin: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkOwner("account", tt.owner, tt.actorID, tt.asAdmin)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+type fakeSource struct {
+	price.Source
+	lock         sync.Mutex
+	feeds        map[string]chan price.Tick
+	unsubscribed []string
+}
+
+func (s *fakeSource) Subscribe(symbol string) (<-chan price.Tick, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, exists := s.feeds[symbol]; exists {
+		return nil, price.ErrSymbolAlreadySubscribed
+	}
+	feed := make(chan price.Tick)
+	s.feeds[symbol] = feed
+	return feed, nil
+}
+
+func (s *fakeSource) Unsubscribe(symbol string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, exists := s.feeds[symbol]; !exists {
+		return price.ErrSymbolNotSubscribed
+	}
+	delete(s.feeds, symbol)
+	s.unsubscribed = append(s.unsubscribed, symbol)
+	return nil
+}
+
+func (s *fakeSource) closeFeed(symbol string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	close(s.feeds[symbol])
+}
+
+func TestSymbolRunners(t *testing.T) {
+	source := &fakeSource{feeds: make(map[string]chan price.Tick)}
+	o := &operator{
+		marketInst:    market.NewMarketWithConfig(market.Config{DefaultPolicy: market.AggregationPolicy{Method: market.LastMethod}}),
+		priceSource:   source,
+		symbolRunners: make(map[string]symbolRunner),
+		stop:          make(chan struct{}),
+	}
+	defer o.Close()
+
+	for _, symbol := range []string{"", "btcusdt", "BTC-USDT", "BTC.USDT"} {
+		assert.ErrorIs(t, o.SubscribeSymbol(symbol), code.InvalidRequest, symbol)
+	}
+	assert.Empty(t, o.ListSymbols())
+
+	require.NoError(t, o.SubscribeSymbol("BTCUSDT"))
+	assert.ErrorIs(t, o.SubscribeSymbol("BTCUSDT"), code.SymbolAlreadySubscribed)
+	assert.Equal(t, []string{"BTCUSDT"}, o.ListSymbols())
+
+	// A feed closing by itself frees the symbol
+	source.closeFeed("BTCUSDT")
+	assert.Eventually(t, func() bool {
+		return len(o.ListSymbols()) == 0
+	}, time.Second, time.Millisecond)
+	source.lock.Lock()
+	assert.Equal(t, []string{"BTCUSDT"}, source.unsubscribed)
+	source.lock.Unlock()
+	require.NoError(t, o.SubscribeSymbol("BTCUSDT"))
+
+	require.NoError(t, o.UnsubscribeSymbol("BTCUSDT"))
+	assert.Empty(t, o.ListSymbols())
+}
+
+// recordingMarket records whether the tick being applied was already acked.
+type recordingMarket struct {
+	market.Market
+	acked       *atomic.Bool
+	ackedBefore []bool
+}
+
+func (m *recordingMarket) UpdatePrice(string, market.Quote) bool {
+	m.ackedBefore = append(m.ackedBefore, m.acked.Load())
+	return true
+}
+
+func TestRunAcksAfterUpdatePrice(t *testing.T) {
+	acked := &atomic.Bool{}
+	m := &recordingMarket{acked: acked}
+	o := &operator{marketInst: m, stop: make(chan struct{})}
+
+	feed := make(chan price.Tick, 1)
+	feed <- price.Tick{Symbol: "BTCUSDT", Price: "1"}.WithAck(func() error {
+		acked.Store(true)
+		return nil
+	})
+	close(feed)
+
+	assert.True(t, o.run("BTCUSDT", feed, make(chan struct{})), "run reports the closed feed")
+	assert.Equal(t, []bool{false}, m.ackedBefore)
+	assert.True(t, acked.Load())
+}
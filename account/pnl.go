@@ -0,0 +1,245 @@
+package account
+
+import (
+	"account-operator/code"
+	"account-operator/market"
+	"account-operator/postgresql"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// PnL is the profit and loss of an account, valued in a reference currency.
+type PnL struct {
+	AccountID string `json:"account_id"`
+	Currency  string `json:"currency"`
+	In        string `json:"in"`
+	Method    string `json:"method"`
+	// Position is the quantity held out of tracked acquisitions and CostBasis what it cost
+	Position  string `json:"position,omitempty"`
+	CostBasis string `json:"cost_basis,omitempty"`
+	Realized  string `json:"realized,omitempty"`
+	// Unrealized is empty when the account currency has no price in the reference currency
+	Unrealized string `json:"unrealized,omitempty"`
+	// UntrackedQuantity was sold without a matching purchase, e.g. deposited funds
+	UntrackedQuantity string `json:"untracked_quantity,omitempty"`
+	// Approximate is set when trades whose value at settlement is unknown were valued at today's rate
+	Approximate bool `json:"approximate"`
+	// Incomplete is set when some trade could not be valued at all, Unpriced lists the currencies
+	// it was against. Only the fields above Position are then set.
+	Incomplete bool     `json:"incomplete"`
+	Unpriced   []string `json:"unpriced,omitempty"`
+}
+
+type UserPnL struct {
+	In         string `json:"in"`
+	Method     string `json:"method"`
+	Accounts   []PnL  `json:"accounts"`
+	Realized   string `json:"realized"`
+	Unrealized string `json:"unrealized"`
+	// Complete is false when the PnL of some account could not be priced, in full or for
+	// its unrealized part. Such accounts are left out of the totals.
+	Complete bool `json:"complete"`
+}
+
+func (o *operator) AccountPnL(userID string, accountID string, currency string, method string) (PnL, error) {
+	dbClient := postgresql.GetClient()
+	var accountCurrency string
+	err := dbClient.QueryRow("SELECT currency FROM account WHERE id = $1 AND owner = $2", accountID, userID).Scan(&accountCurrency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PnL{}, fmt.Errorf("%w : account: %s", code.AccountNotFound, accountID)
+	}
+	if err != nil {
+		return PnL{}, fmt.Errorf("failed to get account: %w", err)
+	}
+	return o.accountPnL(accountID, accountCurrency, currency, method)
+}
+
+func (o *operator) UserPnL(userID string, currency string, method string) (UserPnL, error) {
+	dbClient := postgresql.GetClient()
+	rows, err := dbClient.Query("SELECT id, currency FROM account WHERE owner = $1 AND is_deleted = FALSE ORDER BY currency, name", userID)
+	if err != nil {
+		return UserPnL{}, fmt.Errorf("failed to list accounts: %w", err)
+	}
+	type accountRow struct {
+		id       string
+		currency string
+	}
+	var accounts []accountRow
+	for rows.Next() {
+		var row accountRow
+		err = rows.Scan(&row.id, &row.currency)
+		if err != nil {
+			rows.Close()
+			return UserPnL{}, fmt.Errorf("failed to scan account: %w", err)
+		}
+		accounts = append(accounts, row)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return UserPnL{}, fmt.Errorf("failed to list accounts: %w", err)
+	}
+
+	result := UserPnL{
+		In:       currency,
+		Method:   method,
+		Accounts: make([]PnL, 0, len(accounts)),
+		Complete: true,
+	}
+	var realized, unrealized big.Rat
+	for _, row := range accounts {
+		accountPnL, pnlErr := o.accountPnL(row.id, row.currency, currency, method)
+		if pnlErr != nil {
+			return UserPnL{}, pnlErr
+		}
+		result.Accounts = append(result.Accounts, accountPnL)
+		if accountPnL.Incomplete {
+			result.Complete = false
+			continue
+		}
+
+		var value big.Rat
+		value.SetString(accountPnL.Realized)
+		realized.Add(&realized, &value)
+		if accountPnL.Unrealized == "" {
+			result.Complete = false
+			continue
+		}
+		value.SetString(accountPnL.Unrealized)
+		unrealized.Add(&unrealized, &value)
+	}
+	result.Realized = realized.FloatString(8)
+	result.Unrealized = unrealized.FloatString(8)
+	return result, nil
+}
+
+// accountPnL replays the trades of accountID from transfer_log, leaving out the sweeps of deleted
+// accounts, which are not trades. A trade against the reference currency is valued at its amount,
+// otherwise at the value recorded when it settled, if it was recorded in the reference currency,
+// and only then at the current rate of the counter currency.
+func (o *operator) accountPnL(accountID string, accountCurrency string, currency string, method string) (PnL, error) {
+	if _, err := newCostBasis(method); err != nil {
+		return PnL{}, fmt.Errorf("%w : %s", code.InvalidRequest, err)
+	}
+
+	dbClient := postgresql.GetClient()
+	query := `
+		SELECT t.from_account = $1, t.from_amount, t.to_amount, counter.currency, t.value_currency, t.value
+		FROM transfer_log t
+		JOIN account counter ON counter.id = CASE WHEN t.from_account = $1 THEN t.to_account ELSE t.from_account END
+		WHERE (t.from_account = $1 OR t.to_account = $1) AND t.route <> '[]'::JSONB
+		ORDER BY t.created_at, t.id;
+	`
+	rows, err := dbClient.Query(query, accountID)
+	if err != nil {
+		return PnL{}, fmt.Errorf("failed to list trades: %w", err)
+	}
+	defer rows.Close()
+
+	result := PnL{
+		AccountID: accountID,
+		Currency:  accountCurrency,
+		In:        currency,
+		Method:    method,
+	}
+	// counter currency -> its current rate in the reference currency
+	counterRates := make(map[string]*big.Rat)
+	unpriced := make(map[string]bool)
+	var trades []costTrade
+	for rows.Next() {
+		var disposal bool
+		var fromAmount, toAmount, counterCurrency string
+		var valueCurrency, settledValue sql.NullString
+		err = rows.Scan(&disposal, &fromAmount, &toAmount, &counterCurrency, &valueCurrency, &settledValue)
+		if err != nil {
+			return PnL{}, fmt.Errorf("failed to scan trade: %w", err)
+		}
+
+		quantity, counterAmount := new(big.Rat), new(big.Rat)
+		if disposal {
+			quantity.SetString(fromAmount)
+			counterAmount.SetString(toAmount)
+		} else {
+			quantity.SetString(toAmount)
+			counterAmount.SetString(fromAmount)
+		}
+
+		value := counterAmount
+		switch {
+		case counterCurrency == currency:
+		case valueCurrency.Valid && valueCurrency.String == currency:
+			value.SetString(settledValue.String)
+		default:
+			counterRate, exists := counterRates[counterCurrency]
+			if !exists {
+				counterRate, err = o.rateOf(counterCurrency, currency)
+				if err != nil {
+					return PnL{}, err
+				}
+				counterRates[counterCurrency] = counterRate
+			}
+			if counterRate == nil {
+				unpriced[counterCurrency] = true
+				continue
+			}
+			result.Approximate = true
+			value.Mul(counterAmount, counterRate)
+		}
+
+		trades = append(trades, costTrade{
+			disposal: disposal,
+			quantity: quantity,
+			value:    value,
+		})
+	}
+	if err = rows.Err(); err != nil {
+		return PnL{}, fmt.Errorf("failed to list trades: %w", err)
+	}
+	if len(unpriced) > 0 {
+		result.Incomplete = true
+		for counterCurrency := range unpriced {
+			result.Unpriced = append(result.Unpriced, counterCurrency)
+		}
+		sort.Strings(result.Unpriced)
+		return result, nil
+	}
+
+	costBasisResult, err := computeCostBasis(method, trades)
+	if err != nil {
+		return PnL{}, err
+	}
+	result.Position = costBasisResult.position.FloatString(8)
+	result.CostBasis = costBasisResult.cost.FloatString(8)
+	result.Realized = costBasisResult.realized.FloatString(8)
+	result.UntrackedQuantity = costBasisResult.untracked.FloatString(8)
+
+	positionRate, err := o.rateOf(accountCurrency, currency)
+	if err != nil {
+		return PnL{}, err
+	}
+	if positionRate != nil {
+		var unrealized big.Rat
+		unrealized.Mul(costBasisResult.position, positionRate)
+		unrealized.Sub(&unrealized, costBasisResult.cost)
+		result.Unrealized = unrealized.FloatString(8)
+	}
+	return result, nil
+}
+
+// rateOf returns the live rate of baseCurrency in quoteCurrency, or nil if it is not priced.
+func (o *operator) rateOf(baseCurrency string, quoteCurrency string) (*big.Rat, error) {
+	rate, err := o.marketInst.Rate(baseCurrency, quoteCurrency)
+	if errors.Is(err, market.ErrSymbolNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rate of %s in %s: %w", baseCurrency, quoteCurrency, err)
+	}
+	rateRat, ok := new(big.Rat).SetString(rate.Price)
+	if !ok {
+		return nil, fmt.Errorf("invalid rate of %s in %s: %s", baseCurrency, quoteCurrency, rate.Price)
+	}
+	return rateRat, nil
+}
@@ -0,0 +1,137 @@
+package account
+
+import (
+	"account-operator/code"
+	"account-operator/market"
+	"account-operator/postgresql"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// AccountValuation is the worth of one account in the reference currency of a Portfolio.
+type AccountValuation struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+	Balance  string `json:"balance"`
+	// Held is reserved by withdrawals waiting for approval. It still belongs to the account
+	// until they are approved, so Value includes it.
+	Held string `json:"held"`
+	// Available is false when no price route to the reference currency exists,
+	// Rate and Value are empty in that case
+	Available bool         `json:"available"`
+	Rate      string       `json:"rate,omitempty"`
+	Value     string       `json:"value,omitempty"`
+	Route     []market.Leg `json:"route,omitempty"`
+}
+
+type Portfolio struct {
+	Currency string             `json:"currency"`
+	Accounts []AccountValuation `json:"accounts"`
+	// Total only sums the available accounts, Complete tells whether that is all of them
+	Total    string `json:"total"`
+	Complete bool   `json:"complete"`
+}
+
+func (o *operator) Portfolio(userID string, currency string) (Portfolio, error) {
+	dbClient := postgresql.GetClient()
+
+	// Check if the currency exists
+	var currencyExists bool
+	err := dbClient.QueryRow("SELECT EXISTS(SELECT 1 FROM public.currency WHERE code = $1)", currency).Scan(&currencyExists)
+	if err != nil {
+		return Portfolio{}, fmt.Errorf("failed to check currency: %w", err)
+	}
+	if !currencyExists {
+		return Portfolio{}, fmt.Errorf("%w : currency: %s", code.CurrencyNotFound, currency)
+	}
+
+	// Prepare the SQL statement
+	query := `
+		SELECT id, name, currency, balance, held_balance
+		FROM account
+		WHERE owner = (SELECT id FROM public.users WHERE id = $1) AND is_deleted = FALSE
+		ORDER BY currency, name;
+	`
+
+	// Execute the SQL statement
+	rows, err := dbClient.Query(query, userID)
+	if err != nil {
+		return Portfolio{}, fmt.Errorf("failed to list accounts: %w", err)
+	}
+	defer rows.Close()
+
+	portfolio := Portfolio{
+		Currency: currency,
+		Accounts: make([]AccountValuation, 0),
+		Complete: true,
+	}
+	total := new(big.Rat)
+	for rows.Next() {
+		var valuation AccountValuation
+		err = rows.Scan(&valuation.ID, &valuation.Name, &valuation.Currency, &valuation.Balance, &valuation.Held)
+		if err != nil {
+			return Portfolio{}, fmt.Errorf("failed to scan account: %w", err)
+		}
+
+		value, err := o.valuate(&valuation, currency)
+		if err != nil {
+			return Portfolio{}, err
+		}
+		if valuation.Available {
+			total.Add(total, value)
+		} else {
+			portfolio.Complete = false
+		}
+		portfolio.Accounts = append(portfolio.Accounts, valuation)
+	}
+	if err = rows.Err(); err != nil {
+		return Portfolio{}, fmt.Errorf("failed to list accounts: %w", err)
+	}
+
+	portfolio.Total = total.FloatString(8)
+	return portfolio, nil
+}
+
+// valuate converts the balance and held funds of valuation to currency with the live market rate.
+// A missing price route is not an error, it leaves the valuation unavailable. The value is exact,
+// like the amounts of a settlement, only its text is rounded.
+func (o *operator) valuate(valuation *AccountValuation, currency string) (*big.Rat, error) {
+	rate, err := o.marketInst.Rate(valuation.Currency, currency)
+	if errors.Is(err, market.ErrSymbolNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rate of %s in %s: %w", valuation.Currency, currency, err)
+	}
+
+	value, err := valueOf(valuation.Balance, valuation.Held, rate.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	valuation.Available = true
+	valuation.Rate = rate.Price
+	valuation.Value = value.FloatString(8)
+	valuation.Route = rate.Route
+	return value, nil
+}
+
+// valueOf returns the worth of balance and held funds at price.
+func valueOf(balance string, held string, price string) (*big.Rat, error) {
+	balanceRat, ok := new(big.Rat).SetString(balance)
+	if !ok {
+		return nil, fmt.Errorf("invalid balance: %s", balance)
+	}
+	heldRat, ok := new(big.Rat).SetString(held)
+	if !ok {
+		return nil, fmt.Errorf("invalid held balance: %s", held)
+	}
+	priceRat, ok := new(big.Rat).SetString(price)
+	if !ok {
+		return nil, fmt.Errorf("invalid rate: %s", price)
+	}
+	value := balanceRat.Add(balanceRat, heldRat)
+	return value.Mul(value, priceRat), nil
+}
@@ -0,0 +1,18 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueOf(t *testing.T) {
+	// A float64 would lose the last decimals of this value
+	value, err := valueOf("123456789.12345678", "0.00000001", "29876.54321987")
+	require.NoError(t, err)
+	assert.Equal(t, "3688462096033.33331965", value.FloatString(8))
+
+	_, err = valueOf("1", "", "1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,141 @@
+package account
+
+import (
+	"account-operator/code"
+	"account-operator/postgresql"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Movement types, as written by the statement query
+const (
+	DepositMovement    = "deposit"
+	WithdrawalMovement = "withdrawal"
+	TradeInMovement    = "trade_in"
+	TradeOutMovement   = "trade_out"
+)
+
+type StatementHeader struct {
+	AccountID      string
+	Currency       string
+	From           time.Time
+	To             time.Time
+	OpeningBalance string
+}
+
+// StatementLine is one movement of a statement, Amount is negative for outgoing movements.
+type StatementLine struct {
+	Time           time.Time
+	Type           string
+	Amount         string
+	Balance        string
+	CounterAccount string
+	ExchangeRate   string
+}
+
+// StatementWriter receives a statement as it is read, so it never has to be held in memory.
+type StatementWriter interface {
+	WriteHeader(header StatementHeader) error
+	WriteLine(line StatementLine) error
+	WriteFooter(closingBalance string) error
+}
+
+func (o *operator) Statement(ctx context.Context, userID string, accountID string, from time.Time, to time.Time, w StatementWriter) error {
+	dbClient := postgresql.GetClient()
+
+	// Read the opening balance and the movements from the same snapshot
+	tx, err := dbClient.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	header := StatementHeader{
+		AccountID: accountID,
+		From:      from,
+		To:        to,
+	}
+	err = tx.QueryRowContext(ctx, "SELECT currency FROM account WHERE id = $1 AND owner = $2", accountID, userID).Scan(&header.Currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w : account: %s", code.AccountNotFound, accountID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get account: %w", err)
+	}
+
+	openingQuery := `
+		SELECT COALESCE(SUM(amount), 0)::TEXT
+		FROM (
+			SELECT amount FROM deposit_and_withdrawal_log WHERE account = $1 AND created_at < $2
+			UNION ALL
+			SELECT -from_amount FROM transfer_log WHERE from_account = $1 AND created_at < $2
+			UNION ALL
+			SELECT to_amount FROM transfer_log WHERE to_account = $1 AND created_at < $2
+		) movement;
+	`
+	err = tx.QueryRowContext(ctx, openingQuery, accountID, from).Scan(&header.OpeningBalance)
+	if err != nil {
+		return fmt.Errorf("failed to get opening balance: %w", err)
+	}
+	balance, ok := new(big.Rat).SetString(header.OpeningBalance)
+	if !ok {
+		return fmt.Errorf("invalid opening balance: %s", header.OpeningBalance)
+	}
+	header.OpeningBalance = balance.FloatString(8)
+	err = w.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+
+	movementQuery := `
+		SELECT created_at, kind, amount::TEXT, counter_account, exchange_rate
+		FROM (
+			SELECT created_at, 0 AS source, id, CASE WHEN amount < 0 THEN 'withdrawal' ELSE 'deposit' END AS kind, amount, '' AS counter_account, '' AS exchange_rate
+			FROM deposit_and_withdrawal_log
+			WHERE account = $1 AND created_at >= $2 AND created_at < $3
+			UNION ALL
+			SELECT created_at, 1, id, 'trade_out', -from_amount, to_account::TEXT, exchange_rate::TEXT
+			FROM transfer_log
+			WHERE from_account = $1 AND created_at >= $2 AND created_at < $3
+			UNION ALL
+			SELECT created_at, 1, id, 'trade_in', to_amount, from_account::TEXT, exchange_rate::TEXT
+			FROM transfer_log
+			WHERE to_account = $1 AND created_at >= $2 AND created_at < $3
+		) movement
+		ORDER BY created_at, source, id;
+	`
+	rows, err := tx.QueryContext(ctx, movementQuery, accountID, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to list movements: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line StatementLine
+		err = rows.Scan(&line.Time, &line.Type, &line.Amount, &line.CounterAccount, &line.ExchangeRate)
+		if err != nil {
+			return fmt.Errorf("failed to scan movement: %w", err)
+		}
+		amount, ok := new(big.Rat).SetString(line.Amount)
+		if !ok {
+			return fmt.Errorf("invalid movement amount: %s", line.Amount)
+		}
+		balance.Add(balance, amount)
+		line.Amount = amount.FloatString(8)
+		line.Balance = balance.FloatString(8)
+
+		err = w.WriteLine(line)
+		if err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to list movements: %w", err)
+	}
+
+	return w.WriteFooter(balance.FloatString(8))
+}
@@ -0,0 +1,126 @@
+package account
+
+import (
+	"account-operator/code"
+	"account-operator/postgresql"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+const (
+	maxLabels      = 20
+	maxLabelLength = 64
+)
+
+// UpdateAccountRequest changes the fields that are set and leaves the nil ones untouched.
+type UpdateAccountRequest struct {
+	Name   *string   `json:"name"`
+	Labels *[]string `json:"labels"`
+	// IsDefault marks the account as the default one of its currency, unmarking the previous default
+	IsDefault *bool `json:"is_default"`
+}
+
+func (o *operator) UpdateAccount(userID string, accountID string, req UpdateAccountRequest) (Account, error) {
+	if req.Name != nil && *req.Name == "" {
+		return nil, fmt.Errorf("%w : name cannot be empty", code.InvalidRequest)
+	}
+	if req.Labels != nil {
+		err := validateLabels(*req.Labels)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dbClient := postgresql.GetClient()
+
+	// Start a transaction
+	tx, err := dbClient.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Only the owner can update an account, others are told it does not exist
+	var accountInst account
+	selectQuery := `
+		SELECT id, currency, name, labels, is_default
+		FROM account
+		WHERE id = $1 AND owner = $2 AND is_deleted = FALSE
+		FOR UPDATE;
+	`
+	err = tx.QueryRow(selectQuery, accountID, userID).Scan(&accountInst.id, &accountInst.currency, &accountInst.name, pq.Array(&accountInst.labels), &accountInst.isDefault)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w : account: %s", code.AccountNotFound, accountID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+
+	if req.Name != nil && *req.Name != accountInst.name {
+		err = checkNameAvailable(tx, userID, *req.Name)
+		if err != nil {
+			return nil, err
+		}
+		accountInst.name = *req.Name
+	}
+	if req.Labels != nil {
+		accountInst.labels = *req.Labels
+	}
+	if req.IsDefault != nil {
+		if *req.IsDefault && !accountInst.isDefault {
+			unsetDefaultQuery := `
+				UPDATE account
+				SET is_default = FALSE
+				WHERE owner = $1 AND currency = $2 AND is_default = TRUE;
+			`
+			_, err = tx.Exec(unsetDefaultQuery, userID, accountInst.currency)
+			if err != nil {
+				return nil, fmt.Errorf("failed to unset default account: %w", err)
+			}
+		}
+		accountInst.isDefault = *req.IsDefault
+	}
+
+	updateQuery := `
+		UPDATE account
+		SET name = $1, labels = $2, is_default = $3
+		WHERE id = $4;
+	`
+	_, err = tx.Exec(updateQuery, accountInst.name, pq.Array(accountInst.labels), accountInst.isDefault, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update account: %w", err)
+	}
+
+	// Commit the transaction
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", commitErr)
+	}
+	return &accountInst, nil
+}
+
+// checkNameAvailable fails if userID already has an account named name.
+func checkNameAvailable(tx *sql.Tx, userID string, name string) error {
+	var nameTaken bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM account WHERE owner = $1 AND name = $2 AND is_deleted = FALSE)", userID, name).Scan(&nameTaken)
+	if err != nil {
+		return fmt.Errorf("failed to check account name: %w", err)
+	}
+	if nameTaken {
+		return fmt.Errorf("%w : name: %s", code.AccountNameTaken, name)
+	}
+	return nil
+}
+
+func validateLabels(labels []string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("%w : at most %d labels are allowed", code.InvalidRequest, maxLabels)
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > maxLabelLength {
+			return fmt.Errorf("%w : labels must be between 1 and %d characters", code.InvalidRequest, maxLabelLength)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package account
+
+import (
+	"account-operator/code"
+	"account-operator/outbox"
+	"account-operator/postgresql"
+	"account-operator/protocol"
+	"account-operator/quit"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	WithdrawalPending  = "pending"
+	WithdrawalApproved = "approved"
+	WithdrawalRejected = "rejected"
+	WithdrawalExpired  = "expired"
+)
+
+// Actions of withdrawal_approval_log
+const (
+	withdrawalRequest = "request"
+	withdrawalApprove = "approve"
+	withdrawalReject  = "reject"
+	withdrawalExpire  = "expire"
+)
+
+// withdrawalDirect is a withdrawal below the approval threshold, paid out at once
+const withdrawalDirect = "withdraw"
+
+// systemActor decides withdrawals that expire
+const systemActor = "system"
+
+type PendingWithdrawal struct {
+	ID          int64      `json:"id"`
+	AccountID   string     `json:"account_id"`
+	Amount      string     `json:"amount"`
+	RequestedBy string     `json:"requested_by"`
+	Status      string     `json:"status"`
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+}
+
+// withdrawalPolicy decides which withdrawals need a second admin.
+type withdrawalPolicy struct {
+	// thresholds maps a currency to the amount from which a withdrawal needs approval
+	thresholds     map[string]*big.Rat
+	pendingTTL     time.Duration
+	expiryInterval time.Duration
+}
+
+func loadWithdrawalPolicy() (withdrawalPolicy, error) {
+	policy := withdrawalPolicy{
+		thresholds:     make(map[string]*big.Rat),
+		pendingTTL:     viper.GetDuration("withdraw.pendingTTL"),
+		expiryInterval: viper.GetDuration("withdraw.expiryInterval"),
+	}
+	if policy.pendingTTL <= 0 {
+		policy.pendingTTL = 24 * time.Hour
+	}
+	if policy.expiryInterval <= 0 {
+		policy.expiryInterval = time.Minute
+	}
+	// viper lower-cases keys, currencies are upper case
+	for currency, amount := range viper.GetStringMapString("withdraw.approvalThresholds") {
+		threshold, ok := new(big.Rat).SetString(amount)
+		if !ok || threshold.Sign() < 0 {
+			return withdrawalPolicy{}, fmt.Errorf("invalid approval threshold of %s: %s", currency, amount)
+		}
+		policy.thresholds[strings.ToUpper(currency)] = threshold
+	}
+	return policy, nil
+}
+
+func (p withdrawalPolicy) needsApproval(currency string, amount *big.Rat) bool {
+	threshold, ok := p.thresholds[currency]
+	return ok && amount.Cmp(threshold) >= 0
+}
+
+// fundsMove is what is added to the balance and held_balance of an account.
+type fundsMove struct {
+	balance *big.Rat
+	held    *big.Rat
+}
+
+// withdrawalMove returns how action moves the funds of a withdrawal of amount: withdrawalDirect or
+// withdrawalRequest, and then withdrawalApprove, withdrawalReject or withdrawalExpire. Requesting holds the amount, approving
+// pays the held amount out, and rejecting or expiring gives it back.
+func withdrawalMove(action string, amount *big.Rat) fundsMove {
+	zero := new(big.Rat)
+	negative := new(big.Rat).Neg(amount)
+	switch action {
+	case withdrawalRequest:
+		return fundsMove{balance: negative, held: amount}
+	case withdrawalApprove:
+		return fundsMove{balance: zero, held: negative}
+	case withdrawalReject, withdrawalExpire:
+		return fundsMove{balance: amount, held: negative}
+	default:
+		return fundsMove{balance: negative, held: zero}
+	}
+}
+
+func applyFundsMove(tx *sql.Tx, accountID string, move fundsMove) error {
+	_, err := tx.Exec("UPDATE account SET balance = balance + $1, held_balance = held_balance + $2 WHERE id = $3;",
+		move.balance.FloatString(8), move.held.FloatString(8), accountID)
+	return err
+}
+
+// checkDecision tells whether actorID may move a withdrawal requested by requestedBy from
+// currentStatus to status.
+func checkDecision(withdrawalID int64, requestedBy string, currentStatus string, expired bool, actorID string, status string) error {
+	if currentStatus != WithdrawalPending {
+		return fmt.Errorf("%w : withdrawal: %d is %s", code.WithdrawalNotPending, withdrawalID, currentStatus)
+	}
+	if expired {
+		// The expiry goroutine releases the funds
+		return fmt.Errorf("%w : withdrawal: %d is %s", code.WithdrawalNotPending, withdrawalID, WithdrawalExpired)
+	}
+	// Rejecting your own request is harmless, approving it would defeat the second pair of eyes
+	if status == WithdrawalApproved && actorID == requestedBy {
+		return fmt.Errorf("%w : withdrawal: %d", code.SelfApproval, withdrawalID)
+	}
+	return nil
+}
+
+func (o *operator) Withdraw(actorID string, asAdmin bool, accountID string, amount string) (*PendingWithdrawal, error) {
+	err := isValidAmount(amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to withdraw: %w", err)
+	}
+	amountRat, _ := new(big.Rat).SetString(amount)
+
+	dbClient := postgresql.GetClient()
+
+	// Start a transaction
+	tx, err := dbClient.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var owner, currency string
+	err = tx.QueryRow("SELECT owner, currency FROM account WHERE id = $1 FOR UPDATE", accountID).Scan(&owner, &currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w : account: %s", code.AccountNotFound, accountID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+	err = checkOwner(accountID, owner, actorID, asAdmin)
+	if err != nil {
+		return nil, err
+	}
+	err = checkCanDebit(tx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	err = o.limitsPolicy.checkWithdrawLimits(tx, owner, currency, amountRat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to withdraw: %w", err)
+	}
+
+	if !o.withdrawalPolicy.needsApproval(currency, amountRat) {
+		err = writeWithdrawal(tx, accountID, amount, withdrawalMove(withdrawalDirect, amountRat))
+		if err != nil {
+			return nil, err
+		}
+		// Commit the transaction
+		if commitErr := tx.Commit(); commitErr != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", commitErr)
+		}
+		return nil, nil
+	}
+
+	// Hold the funds so they cannot be spent while the withdrawal waits for approval
+	err = applyFundsMove(tx, accountID, withdrawalMove(withdrawalRequest, amountRat))
+	if err != nil {
+		return nil, fmt.Errorf("failed to hold funds: %w", err)
+	}
+	pending := PendingWithdrawal{
+		AccountID:   accountID,
+		RequestedBy: actorID,
+		Status:      WithdrawalPending,
+	}
+	insertQuery := `
+		INSERT INTO pending_withdrawal (account, amount, requested_by, expires_at)
+		VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))
+		RETURNING id, amount, created_at, expires_at;
+	`
+	err = tx.QueryRow(insertQuery, accountID, amount, actorID, o.withdrawalPolicy.pendingTTL.Seconds()).Scan(&pending.ID, &pending.Amount, &pending.CreatedAt, &pending.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pending withdrawal: %w", err)
+	}
+	err = logWithdrawalDecision(tx, pending.ID, withdrawalRequest, actorID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", commitErr)
+	}
+	return &pending, nil
+}
+
+// writeWithdrawal logs and publishes a withdrawal paid out as move.
+func writeWithdrawal(tx *sql.Tx, accountID string, amount string, move fundsMove) error {
+	// Prepare the SQL statement to insert a log entry
+	logQuery := `
+		INSERT INTO deposit_and_withdrawal_log (account, amount)
+		VALUES ($1, $2);
+	`
+
+	// Execute the SQL statement to insert a log entry
+	_, err := tx.Exec(logQuery, accountID, fmt.Sprintf("-%s", amount))
+	if err != nil {
+		return fmt.Errorf("failed to log withdrawal: %w", err)
+	}
+
+	// Execute the SQL statement to update the account balance
+	err = applyFundsMove(tx, accountID, move)
+	if err != nil {
+		return fmt.Errorf("failed to withdraw: %w", err)
+	}
+
+	// Publish the withdrawal event together with the balance change
+	return outbox.Write(tx, protocol.WithdrawEvent, protocol.AccountEventBody{AccountID: accountID, Amount: amount})
+}
+
+func (o *operator) ListPendingWithdrawals() ([]PendingWithdrawal, error) {
+	query := `
+		SELECT id, account, amount, requested_by, status, decided_by, created_at, expires_at, decided_at
+		FROM pending_withdrawal
+		WHERE status = 'pending'
+		ORDER BY id;
+	`
+	rows, err := postgresql.GetClient().Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending withdrawals: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]PendingWithdrawal, 0)
+	for rows.Next() {
+		var w PendingWithdrawal
+		err = rows.Scan(&w.ID, &w.AccountID, &w.Amount, &w.RequestedBy, &w.Status, &w.DecidedBy, &w.CreatedAt, &w.ExpiresAt, &w.DecidedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pending withdrawal: %w", err)
+		}
+		result = append(result, w)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pending withdrawals: %w", err)
+	}
+	return result, nil
+}
+
+func (o *operator) ApproveWithdrawal(actorID string, withdrawalID int64, reason string) error {
+	return decideWithdrawal(actorID, withdrawalID, WithdrawalApproved, withdrawalApprove, reason)
+}
+
+func (o *operator) RejectWithdrawal(actorID string, withdrawalID int64, reason string) error {
+	return decideWithdrawal(actorID, withdrawalID, WithdrawalRejected, withdrawalReject, reason)
+}
+
+func decideWithdrawal(actorID string, withdrawalID int64, status string, action string, reason string) error {
+	dbClient := postgresql.GetClient()
+
+	// Start a transaction
+	tx, err := dbClient.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var accountID, amount, requestedBy, currentStatus string
+	var expired bool
+	selectQuery := `
+		SELECT account, amount, requested_by, status, expires_at <= NOW()
+		FROM pending_withdrawal
+		WHERE id = $1
+		FOR UPDATE;
+	`
+	err = tx.QueryRow(selectQuery, withdrawalID).Scan(&accountID, &amount, &requestedBy, &currentStatus, &expired)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w : withdrawal: %d", code.WithdrawalNotFound, withdrawalID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get pending withdrawal: %w", err)
+	}
+	err = checkDecision(withdrawalID, requestedBy, currentStatus, expired, actorID, status)
+	if err != nil {
+		return err
+	}
+	amountRat, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return fmt.Errorf("invalid withdrawal amount: %s", amount)
+	}
+
+	// Lock the account as the balance moves
+	_, err = tx.Exec("SELECT 1 FROM account WHERE id = $1 FOR UPDATE", accountID)
+	if err != nil {
+		return fmt.Errorf("failed to lock account: %w", err)
+	}
+	if status == WithdrawalApproved {
+		// The account may have been frozen since the request
+		err = checkCanDebit(tx, accountID)
+		if err != nil {
+			return err
+		}
+		err = writeWithdrawal(tx, accountID, amount, withdrawalMove(action, amountRat))
+	} else {
+		err = applyFundsMove(tx, accountID, withdrawalMove(action, amountRat))
+		if err != nil {
+			err = fmt.Errorf("failed to release held funds: %w", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	err = setWithdrawalStatus(tx, withdrawalID, status, actorID)
+	if err != nil {
+		return err
+	}
+	err = logWithdrawalDecision(tx, withdrawalID, action, actorID, reason)
+	if err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if commitErr := tx.Commit(); commitErr != nil {
+		return fmt.Errorf("failed to commit transaction: %w", commitErr)
+	}
+	return nil
+}
+
+// runWithdrawalExpiry rejects pending withdrawals nobody decided on in time.
+func (o *operator) runWithdrawalExpiry() {
+	g := quit.ReportGoroutine("withdrawal expiry")
+	go func() {
+		defer g.Done()
+		ticker := time.NewTicker(o.withdrawalPolicy.expiryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-o.stop:
+				return
+			case <-ticker.C:
+				expired, err := expireWithdrawals()
+				if err != nil {
+					logrus.Errorf("Failed to expire pending withdrawals: %s", err)
+				}
+				if expired > 0 {
+					logrus.Infof("Expired %d pending withdrawals", expired)
+				}
+			}
+		}
+	}()
+}
+
+func expireWithdrawals() (int, error) {
+	dbClient := postgresql.GetClient()
+
+	// Start a transaction
+	tx, err := dbClient.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Skip rows an admin is deciding on right now
+	selectQuery := `
+		SELECT id, account, amount
+		FROM pending_withdrawal
+		WHERE status = 'pending' AND expires_at <= NOW()
+		ORDER BY id
+		FOR UPDATE SKIP LOCKED;
+	`
+	rows, err := tx.Query(selectQuery)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query expired withdrawals: %w", err)
+	}
+	var expired []PendingWithdrawal
+	for rows.Next() {
+		var w PendingWithdrawal
+		err = rows.Scan(&w.ID, &w.AccountID, &w.Amount)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan expired withdrawal: %w", err)
+		}
+		expired = append(expired, w)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read expired withdrawals: %w", err)
+	}
+
+	for _, w := range expired {
+		amount, ok := new(big.Rat).SetString(w.Amount)
+		if !ok {
+			return 0, fmt.Errorf("invalid amount of withdrawal %d: %s", w.ID, w.Amount)
+		}
+		err = applyFundsMove(tx, w.AccountID, withdrawalMove(withdrawalExpire, amount))
+		if err != nil {
+			return 0, fmt.Errorf("failed to release held funds: %w", err)
+		}
+		err = setWithdrawalStatus(tx, w.ID, WithdrawalExpired, systemActor)
+		if err != nil {
+			return 0, err
+		}
+		err = logWithdrawalDecision(tx, w.ID, withdrawalExpire, systemActor, "")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Commit the transaction
+	if commitErr := tx.Commit(); commitErr != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", commitErr)
+	}
+	return len(expired), nil
+}
+
+func setWithdrawalStatus(tx *sql.Tx, withdrawalID int64, status string, actorID string) error {
+	_, err := tx.Exec("UPDATE pending_withdrawal SET status = $1, decided_by = $2, decided_at = NOW() WHERE id = $3;", status, actorID, withdrawalID)
+	if err != nil {
+		return fmt.Errorf("failed to update withdrawal status: %w", err)
+	}
+	return nil
+}
+
+func logWithdrawalDecision(tx *sql.Tx, withdrawalID int64, action string, actorID string, reason string) error {
+	logQuery := `
+		INSERT INTO withdrawal_approval_log (withdrawal, action, actor, reason)
+		VALUES ($1, $2, $3, $4);
+	`
+	_, err := tx.Exec(logQuery, withdrawalID, action, actorID, reason)
+	if err != nil {
+		return fmt.Errorf("failed to log withdrawal decision: %w", err)
+	}
+	return nil
+}
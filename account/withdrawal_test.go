@@ -0,0 +1,100 @@
+package account
+
+import (
+	"account-operator/code"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithdrawalPolicy(t *testing.T) {
+	viper.Set("withdraw.approvalThresholds", map[string]string{"BTC": "1", "usdt": "10000"})
+	defer viper.Set("withdraw.approvalThresholds", nil)
+
+	policy, err := loadWithdrawalPolicy()
+	require.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, policy.pendingTTL)
+
+	amount := func(s string) *big.Rat {
+		r, _ := new(big.Rat).SetString(s)
+		return r
+	}
+	assert.False(t, policy.needsApproval("BTC", amount("0.99999999")))
+	assert.True(t, policy.needsApproval("BTC", amount("1")))
+	assert.True(t, policy.needsApproval("USDT", amount("10000.5")))
+	assert.False(t, policy.needsApproval("ETH", amount("1000000")), "currencies without a threshold never need approval")
+}
+
+func TestWithdrawalPolicyInvalidThreshold(t *testing.T) {
+	viper.Set("withdraw.approvalThresholds", map[string]string{"BTC": "lots"})
+	defer viper.Set("withdraw.approvalThresholds", nil)
+
+	_, err := loadWithdrawalPolicy()
+	assert.Error(t, err)
+}
+
+func TestWithdrawalMoves(t *testing.T) {
+	amount, _ := new(big.Rat).SetString("1.5")
+	// apply replays actions on an account holding 10 and returns its balance and held funds
+	apply := func(actions ...string) (string, string) {
+		balance, held := big.NewRat(10, 1), new(big.Rat)
+		for _, action := range actions {
+			move := withdrawalMove(action, amount)
+			balance.Add(balance, move.balance)
+			held.Add(held, move.held)
+		}
+		return balance.FloatString(8), held.FloatString(8)
+	}
+
+	tests := []struct {
+		name            string
+		actions         []string
+		expectedBalance string
+		expectedHeld    string
+	}{
+		{name: "Direct", actions: []string{withdrawalDirect}, expectedBalance: "8.50000000", expectedHeld: "0.00000000"},
+		{name: "Hold", actions: []string{withdrawalRequest}, expectedBalance: "8.50000000", expectedHeld: "1.50000000"},
+		{name: "Approve", actions: []string{withdrawalRequest, withdrawalApprove}, expectedBalance: "8.50000000", expectedHeld: "0.00000000"},
+		{name: "Reject", actions: []string{withdrawalRequest, withdrawalReject}, expectedBalance: "10.00000000", expectedHeld: "0.00000000"},
+		{name: "Expire", actions: []string{withdrawalRequest, withdrawalExpire}, expectedBalance: "10.00000000", expectedHeld: "0.00000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balance, held := apply(tt.actions...)
+			assert.Equal(t, tt.expectedBalance, balance)
+			assert.Equal(t, tt.expectedHeld, held)
+		})
+	}
+}
+
+func TestCheckDecision(t *testing.T) {
+	tests := []struct {
+		name          string
+		currentStatus string
+		expired       bool
+		actorID       string
+		status        string
+		expectedErr   error
+	}{
+		{name: "ApproveByAnotherAdmin", currentStatus: WithdrawalPending, actorID: "bob", status: WithdrawalApproved},
+		{name: "SelfApproval", currentStatus: WithdrawalPending, actorID: "alice", status: WithdrawalApproved, expectedErr: code.SelfApproval},
+		{name: "SelfRejection", currentStatus: WithdrawalPending, actorID: "alice", status: WithdrawalRejected},
+		{name: "AlreadyApproved", currentStatus: WithdrawalApproved, actorID: "bob", status: WithdrawalRejected, expectedErr: code.WithdrawalNotPending},
+		{name: "AlreadyExpired", currentStatus: WithdrawalExpired, actorID: "bob", status: WithdrawalApproved, expectedErr: code.WithdrawalNotPending},
+		{name: "PastExpiry", currentStatus: WithdrawalPending, expired: true, actorID: "bob", status: WithdrawalApproved, expectedErr: code.WithdrawalNotPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDecision(1, "alice", tt.currentStatus, tt.expired, tt.actorID, tt.status)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package apikey
+
+import (
+	"account-operator/code"
+	"account-operator/role"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// ScopeRead lets a key read accounts and their history
+	ScopeRead = "read"
+	// ScopeTrade lets a key place orders
+	ScopeTrade = "trade"
+	// ScopeWithdraw lets a key withdraw funds
+	ScopeWithdraw = "withdraw"
+)
+
+var scopePermissions = map[string][]role.Permission{
+	ScopeRead:     {role.AccountRead},
+	ScopeTrade:    {role.AccountRead, role.TradeExecute},
+	ScopeWithdraw: {role.AccountRead, role.FundsWithdraw},
+}
+
+type Key struct {
+	ID          string            `json:"id"`
+	UserID      string            `json:"user_id"`
+	Label       string            `json:"label"`
+	Permissions []role.Permission `json:"permissions"`
+	AllowedIPs  []string          `json:"allowed_ips"`
+	CreatedAt   time.Time         `json:"created_at"`
+	RevokedAt   *time.Time        `json:"revoked_at,omitempty"`
+}
+
+type CreateRequest struct {
+	Label  string   `json:"label"`
+	Scopes []string `json:"scopes" binding:"required"`
+	// AllowedIPs restricts the key to these IPs or CIDRs, any IP is allowed if empty
+	AllowedIPs []string `json:"allowed_ips"`
+}
+
+type Store interface {
+	// Create issues a key for userID and returns it with its secret, which cannot be retrieved later.
+	// The key never gets a permission that granted, the permissions of its creator, lacks.
+	Create(userID string, granted []role.Permission, req CreateRequest) (Key, string, error)
+	List(userID string) ([]Key, error)
+	Revoke(userID string, keyID string) error
+	// Lookup returns an active key with its secret
+	Lookup(keyID string) (Key, string, error)
+}
+
+// permissionsOf resolves scopes to permissions, keeping only those granted to the creator.
+func permissionsOf(scopes []string, granted []role.Permission) ([]role.Permission, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w : at least one scope is required", code.InvalidRequest)
+	}
+	seen := make(map[role.Permission]bool)
+	var permissions []role.Permission
+	for _, scope := range scopes {
+		scopePermission, ok := scopePermissions[scope]
+		if !ok {
+			return nil, fmt.Errorf("%w : unknown scope: %s", code.InvalidRequest, scope)
+		}
+		for _, p := range scopePermission {
+			if !role.Grants(granted, p) {
+				return nil, fmt.Errorf("%w : scope %s needs %s", code.PermissionDenied, scope, p)
+			}
+			if !seen[p] {
+				seen[p] = true
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+func validateAllowedIPs(allowedIPs []string) error {
+	for _, allowed := range allowedIPs {
+		if net.ParseIP(allowed) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(allowed); err != nil {
+			return fmt.Errorf("%w : invalid IP or CIDR: %s", code.InvalidRequest, allowed)
+		}
+	}
+	return nil
+}
+
+// AllowsIP reports whether the key may be used from ip.
+func (k Key) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, allowed := range k.AllowedIPs {
+		if allowedIP := net.ParseIP(allowed); allowedIP != nil {
+			if allowedIP.Equal(parsed) {
+				return true
+			}
+			continue
+		}
+		if _, network, err := net.ParseCIDR(allowed); err == nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the hex HMAC-SHA256 of timestamp, method, request URI and body, which
+// clients send in the X-SIGNATURE header.
+func Sign(secret string, timestamp string, method string, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte(method))
+	mac.Write([]byte(requestURI))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature compares signature with the expected one in constant time.
+func VerifySignature(secret string, signature string, timestamp string, method string, requestURI string, body []byte) bool {
+	expected := Sign(secret, timestamp, method, requestURI, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
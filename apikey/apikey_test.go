@@ -0,0 +1,77 @@
+package apikey
+
+import (
+	"account-operator/code"
+	"account-operator/role"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionsOf(t *testing.T) {
+	admin := role.Permissions([]role.Role{role.Admin})
+	reader := role.Permissions([]role.Role{role.Reader})
+
+	permissions, err := permissionsOf([]string{ScopeRead, ScopeTrade}, admin)
+	require.NoError(t, err)
+	assert.Equal(t, []role.Permission{role.AccountRead, role.TradeExecute}, permissions)
+
+	permissions, err = permissionsOf([]string{ScopeRead}, reader)
+	require.NoError(t, err)
+	assert.Equal(t, []role.Permission{role.AccountRead}, permissions)
+
+	_, err = permissionsOf([]string{ScopeWithdraw}, reader)
+	assert.ErrorIs(t, err, code.PermissionDenied)
+
+	_, err = permissionsOf([]string{"admin"}, admin)
+	assert.ErrorIs(t, err, code.InvalidRequest)
+
+	_, err = permissionsOf(nil, admin)
+	assert.ErrorIs(t, err, code.InvalidRequest)
+}
+
+func TestAllowsIP(t *testing.T) {
+	assert.True(t, Key{}.AllowsIP("203.0.113.7"))
+
+	key := Key{AllowedIPs: []string{"198.51.100.1", "203.0.113.0/24", "2001:db8::/32"}}
+	assert.True(t, key.AllowsIP("198.51.100.1"))
+	assert.True(t, key.AllowsIP("203.0.113.7"))
+	assert.True(t, key.AllowsIP("2001:db8::1"))
+	assert.False(t, key.AllowsIP("198.51.100.2"))
+	assert.False(t, key.AllowsIP("not an ip"))
+
+	assert.NoError(t, validateAllowedIPs(key.AllowedIPs))
+	assert.ErrorIs(t, validateAllowedIPs([]string{"203.0.113.0/33"}), code.InvalidRequest)
+}
+
+func TestSignature(t *testing.T) {
+	signature := Sign("secret", "1700000000000", "POST", "/trade/order", []byte(`{"amount":"1"}`))
+	assert.True(t, VerifySignature("secret", signature, "1700000000000", "POST", "/trade/order", []byte(`{"amount":"1"}`)))
+	assert.False(t, VerifySignature("other", signature, "1700000000000", "POST", "/trade/order", []byte(`{"amount":"1"}`)))
+	assert.False(t, VerifySignature("secret", signature, "1700000000001", "POST", "/trade/order", []byte(`{"amount":"1"}`)))
+	assert.False(t, VerifySignature("secret", signature, "1700000000000", "POST", "/trade/withdraw", []byte(`{"amount":"1"}`)))
+	assert.False(t, VerifySignature("secret", signature, "1700000000000", "POST", "/trade/order", []byte(`{"amount":"2"}`)))
+}
+
+func TestSealer(t *testing.T) {
+	encryptionKey := make([]byte, 32)
+	_, err := rand.Read(encryptionKey)
+	require.NoError(t, err)
+	sealerInst, err := newSealer(base64.StdEncoding.EncodeToString(encryptionKey))
+	require.NoError(t, err)
+
+	sealed, err := sealerInst.seal("key", "secret")
+	require.NoError(t, err)
+	secret, err := sealerInst.open("key", sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", secret)
+
+	_, err = sealerInst.open("other key", sealed)
+	assert.Error(t, err)
+
+	_, err = newSealer(base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package apikey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// sealer encrypts secrets at rest, HMAC verification needs the secret itself so it cannot be hashed.
+type sealer struct {
+	aead cipher.AEAD
+}
+
+// newSealer takes a base64 encoded 32 byte key.
+func newSealer(encodedKey string) (*sealer, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api key encryption key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, errors.New("api key encryption key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &sealer{aead: aead}, nil
+}
+
+func (s *sealer) seal(keyID string, secret string) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	// The key ID is authenticated so a sealed secret cannot be moved to another key
+	return s.aead.Seal(nonce, nonce, []byte(secret), []byte(keyID)), nil
+}
+
+func (s *sealer) open(keyID string, sealed []byte) (string, error) {
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("sealed secret is too short")
+	}
+	secret, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
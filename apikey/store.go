@@ -0,0 +1,161 @@
+package apikey
+
+import (
+	"account-operator/code"
+	"account-operator/postgresql"
+	"account-operator/role"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	keyIDBytes  = 16
+	secretBytes = 32
+)
+
+var errNotConfigured = errors.New("api keys are not configured, set apiKey.encryptionKey")
+
+// NewStore creates a Postgres backed Store. Secrets are sealed with apiKey.encryptionKey,
+// and every operation fails if it is not set.
+func NewStore() (Store, error) {
+	encryptionKey := viper.GetString("apiKey.encryptionKey")
+	if encryptionKey == "" {
+		logrus.Warn("apiKey.encryptionKey is not set, api keys are disabled")
+		return &store{}, nil
+	}
+	sealerInst, err := newSealer(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return &store{sealer: sealerInst}, nil
+}
+
+type store struct {
+	sealer *sealer
+}
+
+func (s *store) Create(userID string, granted []role.Permission, req CreateRequest) (Key, string, error) {
+	if s.sealer == nil {
+		return Key{}, "", errNotConfigured
+	}
+	permissions, err := permissionsOf(req.Scopes, granted)
+	if err != nil {
+		return Key{}, "", err
+	}
+	err = validateAllowedIPs(req.AllowedIPs)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	keyID, err := randomString(keyIDBytes)
+	if err != nil {
+		return Key{}, "", err
+	}
+	secret, err := randomString(secretBytes)
+	if err != nil {
+		return Key{}, "", err
+	}
+	sealed, err := s.sealer.seal(keyID, secret)
+	if err != nil {
+		return Key{}, "", fmt.Errorf("failed to seal secret: %w", err)
+	}
+
+	allowedIPs := req.AllowedIPs
+	if allowedIPs == nil {
+		allowedIPs = []string{}
+	}
+	key := Key{
+		ID:          keyID,
+		UserID:      userID,
+		Label:       req.Label,
+		Permissions: permissions,
+		AllowedIPs:  allowedIPs,
+	}
+	query := `
+		INSERT INTO api_key (id, owner, label, secret, permissions, allowed_ips)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at;
+	`
+	err = postgresql.GetClient().QueryRow(query, keyID, userID, req.Label, sealed, pq.Array(permissions), pq.Array(allowedIPs)).Scan(&key.CreatedAt)
+	if err != nil {
+		return Key{}, "", fmt.Errorf("failed to insert api key: %w", err)
+	}
+	return key, secret, nil
+}
+
+func (s *store) List(userID string) ([]Key, error) {
+	query := `
+		SELECT id, owner, label, permissions, allowed_ips, created_at, revoked_at
+		FROM api_key
+		WHERE owner = $1
+		ORDER BY created_at;
+	`
+	rows, err := postgresql.GetClient().Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]Key, 0)
+	for rows.Next() {
+		var key Key
+		err = rows.Scan(&key.ID, &key.UserID, &key.Label, pq.Array(&key.Permissions), pq.Array(&key.AllowedIPs), &key.CreatedAt, &key.RevokedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate api keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *store) Revoke(userID string, keyID string) error {
+	query := `
+		UPDATE api_key
+		SET revoked_at = NOW()
+		WHERE id = $1 AND owner = $2 AND revoked_at IS NULL;
+	`
+	result, err := postgresql.GetClient().Exec(query, keyID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w : api key: %s", code.APIKeyNotFound, keyID)
+	}
+	return nil
+}
+
+func (s *store) Lookup(keyID string) (Key, string, error) {
+	if s.sealer == nil {
+		return Key{}, "", errNotConfigured
+	}
+	query := `
+		SELECT id, owner, label, secret, permissions, allowed_ips, created_at
+		FROM api_key
+		WHERE id = $1 AND revoked_at IS NULL;
+	`
+	var key Key
+	var sealed []byte
+	err := postgresql.GetClient().QueryRow(query, keyID).Scan(&key.ID, &key.UserID, &key.Label, &sealed, pq.Array(&key.Permissions), pq.Array(&key.AllowedIPs), &key.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Key{}, "", fmt.Errorf("%w : api key: %s", code.APIKeyNotFound, keyID)
+	}
+	if err != nil {
+		return Key{}, "", fmt.Errorf("failed to get api key: %w", err)
+	}
+	secret, err := s.sealer.open(keyID, sealed)
+	if err != nil {
+		return Key{}, "", fmt.Errorf("failed to open secret of api key %s: %w", keyID, err)
+	}
+	return key, secret, nil
+}
@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// genesisHash is the prev_hash of the first entry
+const genesisHash = ""
+
+// StatusStarted is the Status of the entry written before a handler runs. The outcome of the
+// request is a second entry with the HTTP status of the response.
+const StatusStarted = 0
+
+type Entry struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Actor       string    `json:"actor"`
+	Roles       []string  `json:"roles"`
+	APIKeyID    string    `json:"api_key_id"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	Method      string    `json:"method"`
+	Endpoint    string    `json:"endpoint"`
+	PayloadHash string    `json:"payload_hash"`
+	Status      int       `json:"status"`
+	AccountIDs  []string  `json:"account_ids"`
+	PrevHash    string    `json:"prev_hash"`
+	Hash        string    `json:"hash"`
+}
+
+type Filter struct {
+	Actor     string
+	AccountID string
+	From      time.Time
+	To        time.Time
+	// BeforeID pages backwards from the newest entries if set
+	BeforeID int64
+	Limit    int
+}
+
+// VerifyResult tells whether the chain is intact, and if not, the first entry that does not match.
+type VerifyResult struct {
+	Valid    bool  `json:"valid"`
+	Checked  int64 `json:"checked"`
+	BrokenID int64 `json:"broken_id,omitempty"`
+}
+
+type Store interface {
+	// Append chains entry to the last one and stores it
+	Append(entry Entry) error
+	// Query returns the entries matching filter, newest first
+	Query(filter Filter) ([]Entry, error)
+	// Verify recomputes the whole chain
+	Verify() (VerifyResult, error)
+}
+
+// chainHash hashes entry with the hash of the previous entry. The ID is left out as it is assigned
+// by the database, the order is covered by the chain itself.
+func chainHash(prevHash string, entry Entry) string {
+	hashed := struct {
+		PrevHash    string    `json:"prev_hash"`
+		CreatedAt   time.Time `json:"created_at"`
+		Actor       string    `json:"actor"`
+		Roles       []string  `json:"roles"`
+		APIKeyID    string    `json:"api_key_id"`
+		IP          string    `json:"ip"`
+		UserAgent   string    `json:"user_agent"`
+		Method      string    `json:"method"`
+		Endpoint    string    `json:"endpoint"`
+		PayloadHash string    `json:"payload_hash"`
+		Status      int       `json:"status"`
+		AccountIDs  []string  `json:"account_ids"`
+	}{
+		PrevHash:    prevHash,
+		CreatedAt:   entry.CreatedAt.UTC(),
+		Actor:       entry.Actor,
+		Roles:       nonNil(entry.Roles),
+		APIKeyID:    entry.APIKeyID,
+		IP:          entry.IP,
+		UserAgent:   entry.UserAgent,
+		Method:      entry.Method,
+		Endpoint:    entry.Endpoint,
+		PayloadHash: entry.PayloadHash,
+		Status:      entry.Status,
+		AccountIDs:  nonNil(entry.AccountIDs),
+	}
+	// Marshalling a struct has a fixed field order, so the hash is reproducible
+	data, _ := json.Marshal(hashed)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyChain checks entries, oldest first, against prevHash, the hash of the entry before them.
+func verifyChain(prevHash string, entries []Entry) (lastHash string, brokenID int64) {
+	for _, entry := range entries {
+		if entry.PrevHash != prevHash || entry.Hash != chainHash(prevHash, entry) {
+			return prevHash, entry.ID
+		}
+		prevHash = entry.Hash
+	}
+	return prevHash, 0
+}
+
+// PayloadHash returns the hex SHA-256 of a request body.
+func PayloadHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func chain(entries ...Entry) []Entry {
+	prevHash := genesisHash
+	for i := range entries {
+		entries[i].ID = int64(i + 1)
+		entries[i].PrevHash = prevHash
+		entries[i].Hash = chainHash(prevHash, entries[i])
+		prevHash = entries[i].Hash
+	}
+	return entries
+}
+
+func TestVerifyChain(t *testing.T) {
+	createdAt := time.Unix(1700000000, 123000).UTC()
+	newEntries := func() []Entry {
+		return chain(
+			Entry{CreatedAt: createdAt, Actor: "alice", Method: "POST", Endpoint: "/trade/withdraw", Status: 200, AccountIDs: []string{"a1"}},
+			Entry{CreatedAt: createdAt.Add(time.Second), Actor: "bob", Method: "POST", Endpoint: "/trade/deposit", Status: 200, AccountIDs: []string{"a2"}},
+			Entry{CreatedAt: createdAt.Add(2 * time.Second), Actor: "alice", Method: "POST", Endpoint: "/account/freeze", Status: 403},
+		)
+	}
+
+	entries := newEntries()
+	lastHash, brokenID := verifyChain(genesisHash, entries)
+	assert.Zero(t, brokenID)
+	assert.Equal(t, entries[2].Hash, lastHash)
+
+	// Verifying in batches carries the hash over
+	lastHash, brokenID = verifyChain(genesisHash, entries[:1])
+	assert.Zero(t, brokenID)
+	_, brokenID = verifyChain(lastHash, entries[1:])
+	assert.Zero(t, brokenID)
+
+	entries = newEntries()
+	entries[1].Status = 500
+	_, brokenID = verifyChain(genesisHash, entries)
+	assert.Equal(t, int64(2), brokenID, "an edited entry breaks the chain")
+
+	entries = newEntries()
+	_, brokenID = verifyChain(genesisHash, append(entries[:1], entries[2:]...))
+	assert.Equal(t, int64(3), brokenID, "a removed entry breaks the chain")
+
+	entries = newEntries()
+	entries[1].Hash = chainHash(entries[1].PrevHash, Entry{Actor: "mallory"})
+	_, brokenID = verifyChain(genesisHash, entries)
+	assert.Equal(t, int64(2), brokenID, "a rewritten hash does not match the entry")
+}
+
+func TestChainHashIgnoresTimeZone(t *testing.T) {
+	createdAt := time.Unix(1700000000, 0)
+	utc := Entry{CreatedAt: createdAt.UTC()}
+	local := Entry{CreatedAt: createdAt.In(time.FixedZone("UTC+8", 8*3600))}
+	assert.Equal(t, chainHash(genesisHash, utc), chainHash(genesisHash, local))
+
+	// Empty and nil slices are stored the same way
+	assert.Equal(t, chainHash(genesisHash, Entry{Roles: []string{}}), chainHash(genesisHash, Entry{}))
+}
@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"account-operator/postgresql"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+	verifyBatchSize   = 1000
+)
+
+func NewStore() Store {
+	return &store{}
+}
+
+type store struct{}
+
+func (s *store) Append(entry Entry) error {
+	dbClient := postgresql.GetClient()
+
+	// Start a transaction
+	tx, err := dbClient.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Appends are serialized so every entry chains to the one right before it
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext('audit_log'));")
+	if err != nil {
+		return fmt.Errorf("failed to lock audit log: %w", err)
+	}
+	prevHash := genesisHash
+	err = tx.QueryRow("SELECT COALESCE((SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1), '')").Scan(&prevHash)
+	if err != nil {
+		return fmt.Errorf("failed to get last audit hash: %w", err)
+	}
+
+	// Postgres keeps microseconds, hash what is stored
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	entry.Roles = nonNil(entry.Roles)
+	entry.AccountIDs = nonNil(entry.AccountIDs)
+	entry.PrevHash = prevHash
+	entry.Hash = chainHash(prevHash, entry)
+
+	insertQuery := `
+		INSERT INTO audit_log (created_at, actor, roles, api_key, ip, user_agent, method, endpoint, payload_hash, status, account_ids, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);
+	`
+	_, err = tx.Exec(insertQuery, entry.CreatedAt, entry.Actor, pq.Array(entry.Roles), entry.APIKeyID, entry.IP, entry.UserAgent,
+		entry.Method, entry.Endpoint, entry.PayloadHash, entry.Status, pq.Array(entry.AccountIDs), entry.PrevHash, entry.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+
+	// Commit the transaction
+	if commitErr := tx.Commit(); commitErr != nil {
+		return fmt.Errorf("failed to commit transaction: %w", commitErr)
+	}
+	return nil
+}
+
+const selectEntry = `
+	SELECT id, created_at, actor, roles, api_key, ip, user_agent, method, endpoint, payload_hash, status, account_ids, prev_hash, hash
+	FROM audit_log
+`
+
+func (s *store) Query(filter Filter) ([]Entry, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Actor != "" {
+		addCondition("actor = $%d", filter.Actor)
+	}
+	if filter.AccountID != "" {
+		addCondition("$%d = ANY(account_ids)", filter.AccountID)
+	}
+	if !filter.From.IsZero() {
+		addCondition("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("created_at < $%d", filter.To)
+	}
+	if filter.BeforeID > 0 {
+		addCondition("id < $%d", filter.BeforeID)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultQueryLimit
+	}
+	if limit > maxQueryLimit {
+		limit = maxQueryLimit
+	}
+
+	query := selectEntry
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d;", len(args))
+
+	return queryEntries(query, args...)
+}
+
+func (s *store) Verify() (VerifyResult, error) {
+	result := VerifyResult{Valid: true}
+	prevHash := genesisHash
+	var lastID int64
+	for {
+		entries, err := queryEntries(selectEntry+" WHERE id > $1 ORDER BY id LIMIT $2;", lastID, verifyBatchSize)
+		if err != nil {
+			return VerifyResult{}, err
+		}
+		var brokenID int64
+		prevHash, brokenID = verifyChain(prevHash, entries)
+		if brokenID != 0 {
+			result.Valid = false
+			result.BrokenID = brokenID
+			return result, nil
+		}
+		result.Checked += int64(len(entries))
+		if len(entries) < verifyBatchSize {
+			return result, nil
+		}
+		lastID = entries[len(entries)-1].ID
+	}
+}
+
+func queryEntries(query string, args ...interface{}) ([]Entry, error) {
+	rows, err := postgresql.GetClient().Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]Entry, 0)
+	for rows.Next() {
+		var e Entry
+		err = rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, pq.Array(&e.Roles), &e.APIKeyID, &e.IP, &e.UserAgent,
+			&e.Method, &e.Endpoint, &e.PayloadHash, &e.Status, pq.Array(&e.AccountIDs), &e.PrevHash, &e.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
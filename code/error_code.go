@@ -19,14 +19,41 @@ func (e errorCode) Error() string {
 }
 
 var (
-	InternalError    = errorCode{HTTPCode: http.StatusInternalServerError, Message: "internal error"}
-	CurrencyNotFound = errorCode{HTTPCode: http.StatusNotFound, Message: "Currency not found"}
-	UserIDInvalid    = errorCode{HTTPCode: http.StatusBadRequest, Message: "user_id is invalid"}
-	UserIDNotfound   = errorCode{HTTPCode: http.StatusNotFound, Message: "user_id not found"}
-	InvalidRequest   = errorCode{HTTPCode: http.StatusBadRequest, Message: "invalid request"}
-	InvalidToken     = errorCode{HTTPCode: http.StatusUnauthorized, Message: "invalid token"}
-	TokenNotfound    = errorCode{HTTPCode: http.StatusUnauthorized, Message: "token not found"}
-	AccountDeleted   = errorCode{HTTPCode: http.StatusNotFound, Message: "account deleted"}
+	InternalError     = errorCode{HTTPCode: http.StatusInternalServerError, Message: "internal error"}
+	CurrencyNotFound  = errorCode{HTTPCode: http.StatusNotFound, Message: "Currency not found"}
+	UserIDInvalid     = errorCode{HTTPCode: http.StatusBadRequest, Message: "user_id is invalid"}
+	UserIDNotfound    = errorCode{HTTPCode: http.StatusNotFound, Message: "user_id not found"}
+	InvalidRequest    = errorCode{HTTPCode: http.StatusBadRequest, Message: "invalid request"}
+	InvalidToken      = errorCode{HTTPCode: http.StatusUnauthorized, Message: "invalid token"}
+	TokenNotfound     = errorCode{HTTPCode: http.StatusUnauthorized, Message: "token not found"}
+	PermissionDenied  = errorCode{HTTPCode: http.StatusForbidden, Message: "permission denied"}
+	AccountDeleted    = errorCode{HTTPCode: http.StatusNotFound, Message: "account deleted"}
+	AccountNotFound   = errorCode{HTTPCode: http.StatusNotFound, Message: "account not found"}
+	AccountFrozen     = errorCode{HTTPCode: http.StatusForbidden, Message: "account frozen"}
+	AccountNotEmpty   = errorCode{HTTPCode: http.StatusConflict, Message: "account balance is not zero"}
+	AccountNotDeleted = errorCode{HTTPCode: http.StatusConflict, Message: "account not deleted"}
+	AccountNameTaken  = errorCode{HTTPCode: http.StatusConflict, Message: "account name already taken"}
+
+	WithdrawalNotFound   = errorCode{HTTPCode: http.StatusNotFound, Message: "withdrawal not found"}
+	WithdrawalNotPending = errorCode{HTTPCode: http.StatusConflict, Message: "withdrawal not pending"}
+	SelfApproval         = errorCode{HTTPCode: http.StatusForbidden, Message: "withdrawal must be approved by another admin"}
+
+	TokenExpired       = errorCode{HTTPCode: http.StatusUnauthorized, Message: "token expired"}
+	TokenNotYetValid   = errorCode{HTTPCode: http.StatusUnauthorized, Message: "token not yet valid"}
+	TokenWrongIssuer   = errorCode{HTTPCode: http.StatusUnauthorized, Message: "token issuer mismatch"}
+	TokenWrongAudience = errorCode{HTTPCode: http.StatusUnauthorized, Message: "token audience mismatch"}
+	TokenRevoked       = errorCode{HTTPCode: http.StatusUnauthorized, Message: "token revoked"}
+	CSRFCheckFailed    = errorCode{HTTPCode: http.StatusForbidden, Message: "csrf check failed"}
+
+	APIKeyNotFound          = errorCode{HTTPCode: http.StatusNotFound, Message: "api key not found"}
+	InvalidSignature        = errorCode{HTTPCode: http.StatusUnauthorized, Message: "invalid signature"}
+	RequestTimestampExpired = errorCode{HTTPCode: http.StatusUnauthorized, Message: "request timestamp outside receive window"}
+	IPNotAllowed            = errorCode{HTTPCode: http.StatusForbidden, Message: "ip not allowed"}
+
+	SymbolAlreadySubscribed = errorCode{HTTPCode: http.StatusConflict, Message: "symbol already subscribed"}
+	SymbolNotSubscribed     = errorCode{HTTPCode: http.StatusNotFound, Message: "symbol not subscribed"}
+	PriceUnavailable        = errorCode{HTTPCode: http.StatusServiceUnavailable, Message: "price unavailable"}
+	LimitExceeded           = errorCode{HTTPCode: http.StatusTooManyRequests, Message: "limit exceeded"}
 )
 
 func GinResponse(c *gin.Context, err error, additionalMessage ...string) {
@@ -37,7 +64,12 @@ func GinResponse(c *gin.Context, err error, additionalMessage ...string) {
 			c.JSON(e.HTTPCode, gin.H{"error": e.Message})
 			return
 		}
-		c.JSON(e.HTTPCode, gin.H{"error": fmt.Sprintf("%s %s", Message(err), strings.Join(additionalMessage, " "))})
+		body := gin.H{"error": fmt.Sprintf("%s %s", Message(err), strings.Join(additionalMessage, " "))}
+		var d detailedError
+		if errors.As(err, &d) {
+			body["details"] = d.Details()
+		}
+		c.JSON(e.HTTPCode, body)
 		return
 	}
 
@@ -2,6 +2,7 @@ package code
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -38,6 +39,12 @@ func TestGinResponse(t *testing.T) {
 			expectedCode:    http.StatusBadRequest,
 			expectedMessage: "user_id is invalid",
 		},
+		{
+			name:            "LimitExceeded",
+			err:             fmt.Errorf("failed to withdraw: %w", LimitError{Limit: "withdraw_daily", Currency: "BTC", Max: "2", Remaining: "0.5"}),
+			expectedCode:    http.StatusTooManyRequests,
+			expectedMessage: `"details":{"currency":"BTC","limit":"withdraw_daily","max":"2","remaining":"0.5"}`,
+		},
 		{
 			name:            "NoError",
 			err:             nil,
@@ -0,0 +1,42 @@
+package code
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+)
+
+// detailedError carries fields GinResponse adds to the response next to the message.
+type detailedError interface {
+	error
+	Details() gin.H
+}
+
+// LimitError reports the limit a request exceeds and the allowance left under it.
+type LimitError struct {
+	// Limit names the limit, e.g. withdraw_daily or orders_per_minute
+	Limit string
+	// Currency is the currency Max and Remaining are in, empty for counts
+	Currency  string
+	Max       string
+	Remaining string
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("%s : %s limit is %s %s, %s remaining", LimitExceeded.Message, e.Limit, e.Max, e.Currency, e.Remaining)
+}
+
+func (e LimitError) Unwrap() error {
+	return LimitExceeded
+}
+
+func (e LimitError) Details() gin.H {
+	details := gin.H{
+		"limit":     e.Limit,
+		"max":       e.Max,
+		"remaining": e.Remaining,
+	}
+	if e.Currency != "" {
+		details["currency"] = e.Currency
+	}
+	return details
+}
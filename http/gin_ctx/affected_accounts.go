@@ -0,0 +1,15 @@
+package gin_ctx
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// AddAffectedAccount records an account the request changed that is not in the request itself,
+// such as a newly created account, for the audit log.
+func AddAffectedAccount(c *gin.Context, accountID string) {
+	var accountIDs []string
+	if existing, exists := c.Get("affected_accounts"); exists {
+		accountIDs, _ = existing.([]string)
+	}
+	c.Set("affected_accounts", append(accountIDs, accountID))
+}
@@ -0,0 +1,26 @@
+package gin_ctx
+
+import (
+	"account-operator/role"
+	"fmt"
+	"github.com/gin-gonic/gin"
+)
+
+func GetPermissions(c *gin.Context) ([]role.Permission, error) {
+	permissions, exists := c.Get("permissions")
+	if !exists {
+		return nil, fmt.Errorf("permissions not found")
+	}
+
+	permissionsSlice, ok := permissions.([]role.Permission)
+	if !ok {
+		return nil, fmt.Errorf("permissions is not a string slice")
+	}
+	return permissionsSlice, nil
+}
+
+// ActsAsAdmin reports whether the caller may act on the accounts of other users.
+func ActsAsAdmin(c *gin.Context) bool {
+	permissions, err := GetPermissions(c)
+	return err == nil && role.Grants(permissions, role.AdminAccounts)
+}
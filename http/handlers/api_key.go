@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"account-operator/apikey"
+	"account-operator/code"
+	"account-operator/http/gin_ctx"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type RevokeAPIKeyRequest struct {
+	KeyID string `json:"key_id" binding:"required"`
+}
+
+func CreateAPIKey(keys apikey.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req apikey.CreateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+		permissions, err := gin_ctx.GetPermissions(c)
+		if err != nil {
+			code.GinResponse(c, code.InternalError, err.Error())
+			return
+		}
+
+		key, secret, err := keys.Create(userIDStr, permissions, req)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		// The secret is never shown again
+		c.JSON(http.StatusOK, gin.H{"key": key, "secret": secret})
+	}
+}
+
+func ListAPIKeys(keys apikey.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		result, err := keys.List(userIDStr)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func RevokeAPIKey(keys apikey.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RevokeAPIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		err = keys.Revoke(userIDStr, req.KeyID)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Revoke successful"})
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"account-operator/audit"
+	"account-operator/code"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// QueryAudit lists audit entries, newest first, filtered by the actor, account_id, from, to
+// (RFC 3339), before_id and limit query parameters.
+func QueryAudit(auditLog audit.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := audit.Filter{
+			Actor:     c.Query("actor"),
+			AccountID: c.Query("account_id"),
+		}
+		var err error
+		for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+			if value := c.Query(name); value != "" {
+				*target, err = time.Parse(time.RFC3339, value)
+				if err != nil {
+					code.GinResponse(c, code.InvalidRequest, name, "must be an RFC 3339 time")
+					return
+				}
+			}
+		}
+		if value := c.Query("before_id"); value != "" {
+			filter.BeforeID, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				code.GinResponse(c, code.InvalidRequest, "before_id must be an integer")
+				return
+			}
+		}
+		if value := c.Query("limit"); value != "" {
+			filter.Limit, err = strconv.Atoi(value)
+			if err != nil {
+				code.GinResponse(c, code.InvalidRequest, "limit must be an integer")
+				return
+			}
+		}
+
+		entries, err := auditLog.Query(filter)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+func VerifyAudit(auditLog audit.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := auditLog.Verify()
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -3,12 +3,19 @@ package handlers
 import (
 	"account-operator/account"
 	"account-operator/code"
+	"account-operator/http/gin_ctx"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
 
 type DeleteRequest struct {
 	AccountID string `json:"account_id" binding:"required"`
+	// SweepToAccountID receives the remaining balance, it is required unless the balance is zero
+	SweepToAccountID string `json:"sweep_to_account_id"`
+}
+
+type RestoreRequest struct {
+	AccountID string `json:"account_id" binding:"required"`
 }
 
 func Delete(operator account.Operator) gin.HandlerFunc {
@@ -19,12 +26,42 @@ func Delete(operator account.Operator) gin.HandlerFunc {
 			return
 		}
 
-		err := operator.DeleteAccount(req.AccountID)
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		err = operator.DeleteAccount(userIDStr, req.AccountID, req.SweepToAccountID)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Delete successful"})
+	}
+}
+
+func Restore(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RestoreRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		err = operator.RestoreAccount(userIDStr, req.AccountID)
 		if err != nil {
 			code.GinResponse(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Deposit successful"})
+		c.JSON(http.StatusOK, gin.H{"message": "Restore successful"})
 	}
 }
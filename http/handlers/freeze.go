@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"account-operator/account"
+	"account-operator/code"
+	"account-operator/http/gin_ctx"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type FreezeRequest struct {
+	AccountID string `json:"account_id" binding:"required"`
+	// State is debit to only allow deposits, or full to allow nothing
+	State  string `json:"state" binding:"required,oneof=debit full"`
+	Reason string `json:"reason" binding:"required"`
+}
+
+type UnfreezeRequest struct {
+	AccountID string `json:"account_id" binding:"required"`
+	Reason    string `json:"reason" binding:"required"`
+}
+
+func Freeze(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req FreezeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		err = operator.FreezeAccount(userIDStr, req.AccountID, req.State, req.Reason)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Freeze successful"})
+	}
+}
+
+func Unfreeze(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req UnfreezeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		err = operator.UnfreezeAccount(userIDStr, req.AccountID, req.Reason)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Unfreeze successful"})
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"account-operator/account"
+	"account-operator/code"
+	"account-operator/http/gin_ctx"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type SetUserTierRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+	Tier   string `json:"tier" binding:"required"`
+}
+
+func SetUserTier(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SetUserTierRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		err = operator.SetUserTier(userIDStr, req.UserID, req.Tier)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Tier updated"})
+	}
+}
@@ -24,12 +24,18 @@ func ListAccount(operator account.Operator) gin.HandlerFunc {
 		result := make([]gin.H, len(accountInstSlice))
 
 		for i, i2 := range accountInstSlice {
-			result[i] = gin.H{
-				"id":       i2.ID(),
-				"currency": i2.Currency(),
-				"name":     i2.Name(),
-			}
+			result[i] = accountResponse(i2)
 		}
 		c.JSON(http.StatusOK, result)
 	}
 }
+
+func accountResponse(accountInst account.Account) gin.H {
+	return gin.H{
+		"id":         accountInst.ID(),
+		"currency":   accountInst.Currency(),
+		"name":       accountInst.Name(),
+		"labels":     accountInst.Labels(),
+		"is_default": accountInst.IsDefault(),
+	}
+}
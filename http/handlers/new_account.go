@@ -33,6 +33,7 @@ func NewAccount(operator account.Operator) gin.HandlerFunc {
 			return
 		}
 
+		gin_ctx.AddAffectedAccount(c, accountInst.ID())
 		c.JSON(http.StatusOK, gin.H{"account": accountInst.ID()})
 	}
 }
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"account-operator/account"
+	"account-operator/code"
+	"account-operator/http/gin_ctx"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type PnLQuery struct {
+	In     string `form:"in" binding:"required"`
+	Method string `form:"method"`
+}
+
+func (q *PnLQuery) method() string {
+	if q.Method == "" {
+		return account.FIFOMethod
+	}
+	return q.Method
+}
+
+func AccountPnL(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var query PnLQuery
+		if err := c.ShouldBindQuery(&query); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		pnl, err := operator.AccountPnL(userIDStr, c.Param("id"), query.In, query.method())
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, pnl)
+	}
+}
+
+func UserPnL(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var query PnLQuery
+		if err := c.ShouldBindQuery(&query); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		pnl, err := operator.UserPnL(userIDStr, query.In, query.method())
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, pnl)
+	}
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"account-operator/account"
+	"account-operator/code"
+	"account-operator/http/gin_ctx"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func Portfolio(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		currency := c.Query("in")
+		if currency == "" {
+			code.GinResponse(c, code.InvalidRequest, "query parameter in is required")
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		portfolio, err := operator.Portfolio(userIDStr, currency)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, portfolio)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"account-operator/code"
+	"account-operator/http/gin_ctx"
+	"account-operator/revocation"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+type RevokeTokenRequest struct {
+	JTI string `json:"jti" binding:"required"`
+	// ExpiresAt is the exp of the token, the revocation is kept until then. When the caller does not
+	// know it, the revocation is kept for revocation.tokenTTL
+	ExpiresAt *time.Time `json:"expires_at"`
+	Reason    string     `json:"reason"`
+}
+
+type RevokeUserSessionsRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+func RevokeToken(revocations revocation.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RevokeTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		var expiresAt time.Time
+		if req.ExpiresAt != nil {
+			expiresAt = *req.ExpiresAt
+		}
+		err = revocations.RevokeToken(userIDStr, req.JTI, expiresAt, req.Reason)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Revoke successful"})
+	}
+}
+
+func RevokeUserSessions(revocations revocation.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RevokeUserSessionsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		err = revocations.RevokeUser(userIDStr, req.UserID, req.Reason)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Revoke successful"})
+	}
+}
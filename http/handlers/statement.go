@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"account-operator/account"
+	"account-operator/code"
+	"account-operator/http/gin_ctx"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// flushEvery bounds how many statement lines are buffered before they are sent
+const flushEvery = 100
+
+type StatementQuery struct {
+	From   string `form:"from"`
+	To     string `form:"to"`
+	Format string `form:"format"`
+}
+
+func Statement(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var query StatementQuery
+		if err := c.ShouldBindQuery(&query); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+		from, err := parseStatementTime(query.From, time.Unix(0, 0))
+		if err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+		to, err := parseStatementTime(query.To, time.Now())
+		if err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		var w statementWriter
+		switch query.Format {
+		case "", "csv":
+			w = &csvStatementWriter{c: c, w: csv.NewWriter(c.Writer)}
+		case "json":
+			w = &jsonStatementWriter{c: c}
+		default:
+			code.GinResponse(c, code.InvalidRequest, fmt.Sprintf("unknown format: %s", query.Format))
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		err = operator.Statement(c.Request.Context(), userIDStr, c.Param("id"), from, to, w)
+		if err != nil {
+			if !w.started() {
+				code.GinResponse(c, err)
+				return
+			}
+			// The status is already sent, cut the body short so the client sees an incomplete statement
+			logrus.Errorf("Failed to stream statement of account %s: %s", c.Param("id"), err)
+			c.Abort()
+		}
+	}
+}
+
+// parseStatementTime accepts a date or an RFC 3339 time, and returns fallback for an empty value.
+func parseStatementTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s, expected YYYY-MM-DD or RFC 3339", value)
+	}
+	return t, nil
+}
+
+type statementWriter interface {
+	account.StatementWriter
+	// started reports whether the response status was sent
+	started() bool
+}
+
+type csvStatementWriter struct {
+	c     *gin.Context
+	w     *csv.Writer
+	lines int
+	// to is the end of the statement, the time of the closing balance
+	to time.Time
+}
+
+func (s *csvStatementWriter) started() bool {
+	return s.c.Writer.Written()
+}
+
+func (s *csvStatementWriter) WriteHeader(header account.StatementHeader) error {
+	s.c.Header("Content-Type", "text/csv")
+	s.c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=statement-%s.csv", header.AccountID))
+	s.c.Status(http.StatusOK)
+	s.to = header.To
+	records := [][]string{
+		{"time", "type", "amount", "balance", "counter_account", "exchange_rate"},
+		{header.From.Format(time.RFC3339), "opening_balance", "", header.OpeningBalance, "", ""},
+	}
+	return s.w.WriteAll(records)
+}
+
+func (s *csvStatementWriter) WriteLine(line account.StatementLine) error {
+	err := s.w.Write([]string{line.Time.Format(time.RFC3339), line.Type, line.Amount, line.Balance, line.CounterAccount, line.ExchangeRate})
+	if err != nil {
+		return err
+	}
+	s.lines++
+	if s.lines%flushEvery == 0 {
+		s.w.Flush()
+		s.c.Writer.Flush()
+		return s.w.Error()
+	}
+	return nil
+}
+
+func (s *csvStatementWriter) WriteFooter(closingBalance string) error {
+	err := s.w.Write([]string{s.to.Format(time.RFC3339), "closing_balance", "", closingBalance, "", ""})
+	if err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+type jsonStatementWriter struct {
+	c     *gin.Context
+	lines int
+}
+
+type jsonStatementLine struct {
+	Time           time.Time `json:"time"`
+	Type           string    `json:"type"`
+	Amount         string    `json:"amount"`
+	Balance        string    `json:"balance"`
+	CounterAccount string    `json:"counter_account,omitempty"`
+	ExchangeRate   string    `json:"exchange_rate,omitempty"`
+}
+
+func (s *jsonStatementWriter) started() bool {
+	return s.c.Writer.Written()
+}
+
+// WriteHeader opens the statement object, whose movements array is closed by WriteFooter.
+func (s *jsonStatementWriter) WriteHeader(header account.StatementHeader) error {
+	s.c.Header("Content-Type", "application/json")
+	s.c.Status(http.StatusOK)
+	head, err := json.Marshal(gin.H{
+		"account_id":      header.AccountID,
+		"currency":        header.Currency,
+		"from":            header.From,
+		"to":              header.To,
+		"opening_balance": header.OpeningBalance,
+	})
+	if err != nil {
+		return err
+	}
+	// Reopen the object to append the movements
+	_, err = fmt.Fprintf(s.c.Writer, `%s,"movements":[`, head[:len(head)-1])
+	return err
+}
+
+func (s *jsonStatementWriter) WriteLine(line account.StatementLine) error {
+	body, err := json.Marshal(jsonStatementLine(line))
+	if err != nil {
+		return err
+	}
+	if s.lines > 0 {
+		body = append([]byte{','}, body...)
+	}
+	_, err = s.c.Writer.Write(body)
+	if err != nil {
+		return err
+	}
+	s.lines++
+	if s.lines%flushEvery == 0 {
+		s.c.Writer.Flush()
+	}
+	return nil
+}
+
+func (s *jsonStatementWriter) WriteFooter(closingBalance string) error {
+	closing, err := json.Marshal(closingBalance)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.c.Writer, `],"closing_balance":%s}`, closing)
+	return err
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"account-operator/account"
+	"account-operator/code"
+	"account-operator/price"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type SymbolRequest struct {
+	Symbol string `json:"symbol" binding:"required"`
+}
+
+func ListSymbols(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"symbols": operator.ListSymbols()})
+	}
+}
+
+func SubscribeSymbol(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SymbolRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		err := operator.SubscribeSymbol(req.Symbol)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Subscribe successful"})
+	}
+}
+
+func UnsubscribeSymbol(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SymbolRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		err := operator.UnsubscribeSymbol(req.Symbol)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Unsubscribe successful"})
+	}
+}
+
+func PriceStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, price.GetStats())
+	}
+}
@@ -3,7 +3,9 @@ package handlers
 import (
 	"account-operator/account"
 	"account-operator/code"
+	"account-operator/http/gin_ctx"
 	"github.com/gin-gonic/gin"
+	"net/http"
 )
 
 func TradeOrder(operator account.Operator) gin.HandlerFunc {
@@ -13,10 +15,19 @@ func TradeOrder(operator account.Operator) gin.HandlerFunc {
 			code.GinResponse(c, code.InvalidRequest, err.Error())
 			return
 		}
-		err := operator.MarketOrder(req)
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		err = operator.MarketOrder(userIDStr, gin_ctx.ActsAsAdmin(c), req)
 		if err != nil {
 			code.GinResponse(c, err)
 			return
 		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Trade successful"})
 	}
 }
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"account-operator/account"
+	"account-operator/code"
+	"account-operator/http/gin_ctx"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func UpdateAccount(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req account.UpdateAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		accountInst, err := operator.UpdateAccount(userIDStr, c.Param("id"), req)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, accountResponse(accountInst))
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"account-operator/account"
 	"account-operator/code"
+	"account-operator/http/gin_ctx"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
@@ -12,6 +13,11 @@ type WithdrawRequest struct {
 	Amount    string `json:"amount" binding:"required"`
 }
 
+type WithdrawalDecisionRequest struct {
+	WithdrawalID int64  `json:"withdrawal_id" binding:"required"`
+	Reason       string `json:"reason"`
+}
+
 func Withdraw(operator account.Operator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req WithdrawRequest
@@ -20,12 +26,64 @@ func Withdraw(operator account.Operator) gin.HandlerFunc {
 			return
 		}
 
-		err := operator.Withdraw(req.AccountID, req.Amount)
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		pending, err := operator.Withdraw(userIDStr, gin_ctx.ActsAsAdmin(c), req.AccountID, req.Amount)
 		if err != nil {
 			code.GinResponse(c, err)
 			return
 		}
+		if pending != nil {
+			c.JSON(http.StatusAccepted, gin.H{"message": "Withdraw pending approval", "withdrawal": pending})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{"message": "Withdraw successful"})
 	}
 }
+
+func ListPendingWithdrawals(operator account.Operator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := operator.ListPendingWithdrawals()
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func ApproveWithdrawal(operator account.Operator) gin.HandlerFunc {
+	return decideWithdrawal(operator.ApproveWithdrawal, "Approve successful")
+}
+
+func RejectWithdrawal(operator account.Operator) gin.HandlerFunc {
+	return decideWithdrawal(operator.RejectWithdrawal, "Reject successful")
+}
+
+func decideWithdrawal(decide func(actorID string, withdrawalID int64, reason string) error, message string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req WithdrawalDecisionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			code.GinResponse(c, code.InvalidRequest, err.Error())
+			return
+		}
+
+		userIDStr, err := gin_ctx.GetUserID(c)
+		if err != nil {
+			code.GinResponse(c, code.UserIDInvalid, err.Error())
+			return
+		}
+
+		err = decide(userIDStr, req.WithdrawalID, req.Reason)
+		if err != nil {
+			code.GinResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": message})
+	}
+}
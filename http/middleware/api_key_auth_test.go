@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"account-operator/apikey"
+	"account-operator/code"
+	"account-operator/http/gin_ctx"
+	"account-operator/role"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeKeyStore struct {
+	apikey.Store
+	key    apikey.Key
+	secret string
+}
+
+func (s *fakeKeyStore) Lookup(keyID string) (apikey.Key, string, error) {
+	if keyID != s.key.ID {
+		return apikey.Key{}, "", code.APIKeyNotFound
+	}
+	return s.key, s.secret, nil
+}
+
+// revokedUsers revokes the sessions of users issued before their cutoff.
+type revokedUsers map[string]time.Time
+
+func (r revokedUsers) Check(jwt.MapClaims) error {
+	return nil
+}
+
+func (r revokedUsers) CheckUser(userID string, issuedAt time.Time) error {
+	before, revoked := r[userID]
+	if revoked && !issuedAt.After(before) {
+		return code.TokenRevoked
+	}
+	return nil
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys := &fakeKeyStore{
+		key:    apikey.Key{ID: "bot", UserID: "user", CreatedAt: time.Now().Add(-time.Hour), Permissions: []role.Permission{role.AccountRead, role.TradeExecute}},
+		secret: "secret",
+	}
+	revoked := revokedUsers{}
+	r := gin.New()
+	handler := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, c.GetString("user_id")+" "+string(body))
+	}
+	r.POST("/trade/order", Authenticate(keys, revoked), CSRFProtect(), RequirePermission(role.TradeExecute), ParseUserID(), handler)
+	r.POST("/trade/withdraw", Authenticate(keys, revoked), CSRFProtect(), RequirePermission(role.FundsWithdraw), ParseUserID(), handler)
+
+	type request struct {
+		path      string
+		keyID     string
+		timestamp time.Time
+		body      string
+		signBody  string
+		secret    string
+		remoteIP  string
+	}
+	send := func(req request) *httptest.ResponseRecorder {
+		timestamp := strconv.FormatInt(req.timestamp.UnixMilli(), 10)
+		signBody := req.body
+		if req.signBody != "" {
+			signBody = req.signBody
+		}
+		httpReq := httptest.NewRequest(http.MethodPost, req.path, strings.NewReader(req.body))
+		httpReq.Header.Set(APIKeyHeader, req.keyID)
+		httpReq.Header.Set(TimestampHeader, timestamp)
+		httpReq.Header.Set(SignatureHeader, apikey.Sign(req.secret, timestamp, http.MethodPost, req.path, []byte(signBody)))
+		if req.remoteIP != "" {
+			httpReq.RemoteAddr = req.remoteIP + ":1234"
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httpReq)
+		return w
+	}
+	valid := func() request {
+		return request{path: "/trade/order", keyID: "bot", timestamp: time.Now(), body: `{"amount":"1"}`, secret: "secret"}
+	}
+
+	w := send(valid())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `user {"amount":"1"}`, w.Body.String())
+
+	req := valid()
+	req.secret = "wrong"
+	assert.Equal(t, http.StatusUnauthorized, send(req).Code)
+
+	req = valid()
+	req.signBody = `{"amount":"1000"}`
+	assert.Equal(t, http.StatusUnauthorized, send(req).Code)
+
+	req = valid()
+	req.timestamp = time.Now().Add(-time.Minute)
+	assert.Equal(t, http.StatusUnauthorized, send(req).Code)
+
+	req = valid()
+	req.keyID = "unknown"
+	assert.Equal(t, http.StatusUnauthorized, send(req).Code)
+
+	req = valid()
+	req.path = "/trade/withdraw"
+	assert.Equal(t, http.StatusForbidden, send(req).Code)
+
+	keys.key.AllowedIPs = []string{"203.0.113.0/24"}
+	req = valid()
+	req.remoteIP = "198.51.100.1"
+	assert.Equal(t, http.StatusForbidden, send(req).Code)
+	req.remoteIP = "203.0.113.9"
+	assert.Equal(t, http.StatusOK, send(req).Code)
+
+	keys.key.AllowedIPs = nil
+
+	// Keys created before the owner was banned stop working, later ones do not
+	revoked["user"] = time.Now().Add(-time.Minute)
+	w = send(valid())
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), code.TokenRevoked.Message)
+	keys.key.CreatedAt = time.Now()
+	assert.Equal(t, http.StatusOK, send(valid()).Code)
+}
+
+func TestAPIKeyOfAnotherUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// The key of mallory grants every permission of an admin but admin:accounts
+	keys := &fakeKeyStore{
+		key:    apikey.Key{ID: "bot", UserID: "mallory", CreatedAt: time.Now().Add(-time.Hour), Permissions: []role.Permission{role.FundsWithdraw, role.TradeExecute, role.AdminWithdrawals}},
+		secret: "secret",
+	}
+	r := gin.New()
+	r.POST("/trade/withdraw", Authenticate(keys, revokedUsers{}), CSRFProtect(), RequirePermission(role.FundsWithdraw), ParseUserID(), func(c *gin.Context) {
+		c.String(http.StatusOK, "%s %t", c.GetString("user_id"), gin_ctx.ActsAsAdmin(c))
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		body := `{"account_id":"account-of-alice","amount":"1"}`
+		httpReq := httptest.NewRequest(http.MethodPost, "/trade/withdraw", strings.NewReader(body))
+		httpReq.Header.Set(APIKeyHeader, "bot")
+		httpReq.Header.Set(TimestampHeader, timestamp)
+		httpReq.Header.Set(SignatureHeader, apikey.Sign("secret", timestamp, http.MethodPost, "/trade/withdraw", []byte(body)))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httpReq)
+		return w
+	}
+
+	// The request acts for the owner of the key, who may only move the funds of their own accounts
+	w := send()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mallory false", w.Body.String())
+
+	keys.key.Permissions = append(keys.key.Permissions, role.AdminAccounts)
+	assert.Equal(t, "mallory true", send().Body.String())
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"account-operator/audit"
+	"account-operator/code"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	auditBodyKey    = "audit_body"
+	auditStartedKey = "audit_started"
+)
+
+// Audit records the outcome of every state-changing request once it has been handled. Requests
+// rejected before reaching their handler are recorded only if the caller authenticated, so that
+// anonymous clients cannot flood the audit log. Bodies are limited to the size of signed bodies.
+func Audit(store audit.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isSafeMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSignedBodySize))
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				code.GinResponse(c, code.InvalidRequest, "body is too large")
+				c.Abort()
+				return
+			}
+			if err != nil {
+				code.GinResponse(c, code.InvalidRequest, err.Error())
+				c.Abort()
+				return
+			}
+			// Handlers bind the body again
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		c.Set(auditBodyKey, body)
+
+		c.Next()
+
+		if !c.GetBool(auditStartedKey) && c.GetString("auth_method") == "" {
+			return
+		}
+		entry := auditEntry(c, body, c.Writer.Status())
+		err := store.Append(entry)
+		if err != nil {
+			// AuditStart already recorded the request
+			logrus.Errorf("Failed to audit the outcome of %s %s by %q: %s", entry.Method, entry.Endpoint, entry.Actor, err)
+		}
+	}
+}
+
+// AuditStart records a state-changing request with audit.StatusStarted right before its handler
+// runs, after Audit read the body, and rejects the request if it cannot be recorded. Every call
+// that may change state is thus in the audit log even if recording its outcome fails.
+func AuditStart(store audit.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isSafeMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		body, _ := c.Get(auditBodyKey)
+		bodyBytes, _ := body.([]byte)
+		entry := auditEntry(c, bodyBytes, audit.StatusStarted)
+		err := store.Append(entry)
+		if err != nil {
+			code.GinResponse(c, fmt.Errorf("%w : %s", code.InternalError, err))
+			c.Abort()
+			return
+		}
+		c.Set(auditStartedKey, true)
+		c.Next()
+	}
+}
+
+func auditEntry(c *gin.Context, body []byte, status int) audit.Entry {
+	entry := audit.Entry{
+		Actor:       c.GetString("user_id"),
+		APIKeyID:    c.GetString("api_key_id"),
+		IP:          c.ClientIP(),
+		UserAgent:   c.Request.UserAgent(),
+		Method:      c.Request.Method,
+		Endpoint:    c.Request.URL.Path,
+		PayloadHash: audit.PayloadHash(body),
+		Status:      status,
+		AccountIDs:  affectedAccounts(c, body),
+	}
+	if claims, err := claimsFromContext(c); err == nil {
+		entry.Roles, _ = rolesFromClaims(claims)
+		if entry.Actor == "" {
+			// Routes acting on no user do not parse user_id
+			entry.Actor, _ = claims["user_id"].(string)
+		}
+	}
+	return entry
+}
+
+// affectedAccounts collects the :id path parameter, the account IDs of the JSON body,
+// which are the fields named account_id or ending with _account_id, and the ones
+// handlers added with gin_ctx.AddAffectedAccount.
+func affectedAccounts(c *gin.Context, body []byte) []string {
+	ids := make(map[string]bool)
+	if id := c.Param("id"); id != "" {
+		ids[id] = true
+	}
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) == nil {
+		for name, value := range fields {
+			id, ok := value.(string)
+			if ok && id != "" && (name == "account_id" || strings.HasSuffix(name, "_account_id")) {
+				ids[id] = true
+			}
+		}
+	}
+	if added, ok := c.Get("affected_accounts"); ok {
+		if addedIDs, ok := added.([]string); ok {
+			for _, id := range addedIDs {
+				ids[id] = true
+			}
+		}
+	}
+
+	result := make([]string, 0, len(ids))
+	for id := range ids {
+		result = append(result, id)
+	}
+	sort.Strings(result)
+	return result
+}
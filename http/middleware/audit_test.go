@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"account-operator/audit"
+	"account-operator/http/gin_ctx"
+	"account-operator/role"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAuditLog struct {
+	audit.Store
+	entries []audit.Entry
+	err     error
+}
+
+func (l *fakeAuditLog) Append(entry audit.Entry) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+func TestAudit(t *testing.T) {
+	key := newSigningKey(t)
+	gin.SetMode(gin.TestMode)
+	auditLog := &fakeAuditLog{}
+
+	r := gin.New()
+	r.Use(Audit(auditLog))
+	handled := 0
+	r.POST("/trade/order", ParseToken(noRevocations{}), RequirePermission(role.TradeExecute), ParseUserID(), AuditStart(auditLog), func(c *gin.Context) {
+		handled++
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+	r.PATCH("/account/:id", ParseToken(noRevocations{}), ParseUserID(), AuditStart(auditLog), func(c *gin.Context) {
+		gin_ctx.AddAffectedAccount(c, "created")
+		c.Status(http.StatusOK)
+	})
+	r.GET("/account/list", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func(method string, path string, tokenString string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("User-Agent", "bot/1.0")
+		if tokenString != "" {
+			req.Header.Set("Authorization", "Bearer "+tokenString)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	admin := signToken(t, key, jwt.MapClaims{"user_id": "alice", "roles": []string{role.Admin}})
+	reader := signToken(t, key, jwt.MapClaims{"user_id": "bob", "roles": role.Reader})
+	order := `{"base_account_id":"b1","quote_account_id":"q1","symbol":"BTCUSDT"}`
+
+	w := send(http.MethodPost, "/trade/order", admin, order)
+	assert.Equal(t, order, w.Body.String(), "the handler still reads the body")
+	send(http.MethodPost, "/trade/order", reader, order)
+	send(http.MethodPost, "/trade/order", "", order)
+	send(http.MethodPatch, "/account/a1", admin, `{"name":"savings"}`)
+	send(http.MethodGet, "/account/list", admin, "")
+
+	require.Len(t, auditLog.entries, 5, "only state-changing requests of authenticated callers are audited")
+
+	started := auditLog.entries[0]
+	assert.Equal(t, audit.StatusStarted, started.Status)
+	assert.Equal(t, "alice", started.Actor)
+	assert.Equal(t, audit.PayloadHash([]byte(order)), started.PayloadHash)
+
+	allowed := auditLog.entries[1]
+	assert.Equal(t, "alice", allowed.Actor)
+	assert.Equal(t, []string{role.Admin}, allowed.Roles)
+	assert.Equal(t, http.MethodPost, allowed.Method)
+	assert.Equal(t, "/trade/order", allowed.Endpoint)
+	assert.Equal(t, http.StatusOK, allowed.Status)
+	assert.Equal(t, "bot/1.0", allowed.UserAgent)
+	assert.NotEmpty(t, allowed.IP)
+	assert.Equal(t, audit.PayloadHash([]byte(order)), allowed.PayloadHash)
+	assert.Equal(t, []string{"b1", "q1"}, allowed.AccountIDs)
+
+	denied := auditLog.entries[2]
+	assert.Equal(t, "bob", denied.Actor, "the actor is known even if it was denied before ParseUserID")
+	assert.Equal(t, http.StatusForbidden, denied.Status)
+
+	assert.Equal(t, []string{"a1"}, auditLog.entries[3].AccountIDs)
+	assert.Equal(t, []string{"a1", "created"}, auditLog.entries[4].AccountIDs)
+
+	// Bodies are capped before anything is read or recorded
+	w = send(http.MethodPost, "/trade/order", admin, strings.Repeat("a", maxSignedBodySize+1))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, auditLog.entries, 5)
+
+	// A request that cannot be recorded is not handled
+	handled = 0
+	auditLog.err = errors.New("database is down")
+	w = send(http.MethodPost, "/trade/order", admin, order)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Zero(t, handled)
+}
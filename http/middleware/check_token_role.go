@@ -3,47 +3,63 @@ package middleware
 import (
 	"account-operator/code"
 	"account-operator/role"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
-func CheckTokenRole(expectedRole role.Role) gin.HandlerFunc {
+// CheckTokenRole lets the request through if the token has any of expectedRoles.
+func CheckTokenRole(expectedRoles ...role.Role) gin.HandlerFunc {
+	return checkTokenRoles(expectedRoles, false)
+}
+
+// CheckTokenAllRoles lets the request through only if the token has all of expectedRoles.
+func CheckTokenAllRoles(expectedRoles ...role.Role) gin.HandlerFunc {
+	return checkTokenRoles(expectedRoles, true)
+}
+
+func checkTokenRoles(expectedRoles []role.Role, requireAll bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		claims, exist := c.Get("jwt_claims")
-		if !exist {
-			code.GinResponse(c, code.InternalError)
-			logrus.Error("jwt_claims not found")
-			c.Abort()
-			return
-		}
-		mapClaims, ok := claims.(jwt.MapClaims)
-		if !ok {
+		claims, err := claimsFromContext(c)
+		if err != nil {
 			code.GinResponse(c, code.InternalError)
-			logrus.Error("jwt_claims is invalid")
+			logrus.Error(err)
 			c.Abort()
 			return
 		}
 
-		roleInClaims, exist := mapClaims["roles"]
-		if !exist {
-			code.GinResponse(c, code.InvalidToken, "role not found in jwt_claims")
+		roles, err := rolesFromClaims(claims)
+		if err != nil {
+			code.GinResponse(c, code.InvalidToken, err.Error())
 			c.Abort()
 			return
 		}
+		logrus.Debugf("roles: %v", roles)
 
-		if roleArray, okRoleArray := roleInClaims.([]string); okRoleArray {
-			logrus.Debugf("roleArray: %v", roleArray)
-			for _, r := range roleArray {
-				if r == expectedRole {
-					c.Next()
-					return
-				}
-			}
-			code.GinResponse(c, code.InvalidToken, "role is invalid")
+		if !matchRoles(roles, expectedRoles, requireAll) {
+			code.GinResponse(c, code.PermissionDenied, "role is invalid")
 			c.Abort()
 			return
 		}
 		c.Next()
 	}
 }
+
+// matchRoles denies when nothing is expected, so a misconfigured route is never left open.
+func matchRoles(roles []role.Role, expectedRoles []role.Role, requireAll bool) bool {
+	if len(expectedRoles) == 0 {
+		return false
+	}
+	has := make(map[role.Role]bool, len(roles))
+	for _, r := range roles {
+		has[r] = true
+	}
+	for _, expected := range expectedRoles {
+		if has[expected] && !requireAll {
+			return true
+		}
+		if !has[expected] && requireAll {
+			return false
+		}
+	}
+	return requireAll
+}
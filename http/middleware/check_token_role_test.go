@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"account-operator/role"
+	"account-operator/token"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type noRevocations struct{}
+
+func (noRevocations) Check(jwt.MapClaims) error {
+	return nil
+}
+
+func (noRevocations) CheckUser(string, time.Time) error {
+	return nil
+}
+
+func newSigningKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	token.SetVerifyKey(&key.PublicKey)
+	t.Cleanup(func() {
+		token.SetVerifyKey(nil)
+	})
+	return key
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	if _, exist := claims["exp"]; !exist {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	require.NoError(t, err)
+	return tokenString
+}
+
+func serve(t *testing.T, tokenString string, guard gin.HandlerFunc) int {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", ParseToken(noRevocations{}), guard, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if tokenString != "" {
+		req.AddCookie(&http.Cookie{Name: "token", Value: tokenString})
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestCheckTokenRole(t *testing.T) {
+	key := newSigningKey(t)
+
+	tests := []struct {
+		name         string
+		roles        interface{}
+		guard        gin.HandlerFunc
+		expectedCode int
+	}{
+		{name: "ArrayWithRole", roles: []string{role.Reader, role.Admin}, guard: CheckTokenRole(role.Admin), expectedCode: http.StatusOK},
+		{name: "ArrayWithoutRole", roles: []string{role.Reader}, guard: CheckTokenRole(role.Admin), expectedCode: http.StatusForbidden},
+		{name: "StringWithRole", roles: role.Admin, guard: CheckTokenRole(role.Admin), expectedCode: http.StatusOK},
+		{name: "StringWithoutRole", roles: role.Reader, guard: CheckTokenRole(role.Admin), expectedCode: http.StatusForbidden},
+		{name: "MissingRoles", roles: nil, guard: CheckTokenRole(role.Admin), expectedCode: http.StatusUnauthorized},
+		{name: "UnparseableRoles", roles: 42, guard: CheckTokenRole(role.Admin), expectedCode: http.StatusUnauthorized},
+		{name: "NonStringRoleInArray", roles: []interface{}{role.Admin, 42}, guard: CheckTokenRole(role.Admin), expectedCode: http.StatusUnauthorized},
+		{name: "EmptyArray", roles: []string{}, guard: CheckTokenRole(role.Admin), expectedCode: http.StatusForbidden},
+		{name: "AnyOfMatches", roles: []string{role.Reader}, guard: CheckTokenRole(role.Admin, role.Reader), expectedCode: http.StatusOK},
+		{name: "AllOfMatches", roles: []string{role.Reader, role.Admin}, guard: CheckTokenAllRoles(role.Admin, role.Reader), expectedCode: http.StatusOK},
+		{name: "AllOfMissingOne", roles: []string{role.Admin}, guard: CheckTokenAllRoles(role.Admin, role.Reader), expectedCode: http.StatusForbidden},
+		{name: "NothingExpected", roles: []string{role.Admin}, guard: CheckTokenRole(), expectedCode: http.StatusForbidden},
+		{name: "NothingExpectedAllOf", roles: []string{role.Admin}, guard: CheckTokenAllRoles(), expectedCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.MapClaims{"user_id": "user"}
+			if tt.roles != nil {
+				claims["roles"] = tt.roles
+			}
+			assert.Equal(t, tt.expectedCode, serve(t, signToken(t, key, claims), tt.guard))
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	key := newSigningKey(t)
+
+	readerToken := signToken(t, key, jwt.MapClaims{"roles": []string{role.Reader}})
+	adminToken := signToken(t, key, jwt.MapClaims{"roles": role.Admin})
+
+	assert.Equal(t, http.StatusOK, serve(t, readerToken, RequirePermission(role.AccountRead)))
+	assert.Equal(t, http.StatusForbidden, serve(t, readerToken, RequirePermission(role.TradeExecute)))
+	assert.Equal(t, http.StatusOK, serve(t, adminToken, RequirePermission(role.AdminSymbols)))
+}
+
+func TestParseTokenRejectsBadTokens(t *testing.T) {
+	key := newSigningKey(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{"roles": []string{role.Admin}}
+	assert.Equal(t, http.StatusUnauthorized, serve(t, "", CheckTokenRole(role.Admin)))
+	assert.Equal(t, http.StatusUnauthorized, serve(t, signToken(t, otherKey, claims), CheckTokenRole(role.Admin)))
+	expired := jwt.MapClaims{"roles": []string{role.Admin}, "exp": time.Now().Add(-time.Hour).Unix()}
+	assert.Equal(t, http.StatusUnauthorized, serve(t, signToken(t, key, expired), CheckTokenRole(role.Admin)))
+}
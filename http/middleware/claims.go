@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"account-operator/role"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// claimsFromContext returns the claims ParseToken stored in the context.
+func claimsFromContext(c *gin.Context) (jwt.MapClaims, error) {
+	claims, exist := c.Get("jwt_claims")
+	if !exist {
+		return nil, errors.New("jwt_claims not found")
+	}
+	mapClaims, ok := claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("jwt_claims is invalid")
+	}
+	return mapClaims, nil
+}
+
+// rolesFromClaims reads the roles claim, which is either a single role or an array of roles.
+func rolesFromClaims(claims jwt.MapClaims) ([]role.Role, error) {
+	roleInClaims, exist := claims["roles"]
+	if !exist {
+		return nil, errors.New("role not found in jwt_claims")
+	}
+
+	switch roles := roleInClaims.(type) {
+	case string:
+		return []role.Role{roles}, nil
+	case []string:
+		return roles, nil
+	case []interface{}:
+		result := make([]role.Role, len(roles))
+		for i, r := range roles {
+			roleString, ok := r.(string)
+			if !ok {
+				return nil, fmt.Errorf("role %v is not a string", r)
+			}
+			result[i] = roleString
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("roles claim has unexpected type %T", roleInClaims)
+	}
+}
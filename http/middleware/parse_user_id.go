@@ -8,6 +8,12 @@ import (
 
 func ParseUserID() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// API keys set user_id themselves
+		if _, exist := c.Get("user_id"); exist {
+			c.Next()
+			return
+		}
+
 		jet_claim, exist := c.Get("jwt_claims")
 		if !exist {
 			code.GinResponse(c, code.InternalError, "jwt_claims not found")
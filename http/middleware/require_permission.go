@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"account-operator/code"
+	"account-operator/role"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// RequirePermission lets the request through only if its permissions grant permission.
+// API keys carry their own permissions, tokens get those of their roles.
+func RequirePermission(permission role.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permissions, err := requestPermissions(c)
+		if err != nil {
+			return
+		}
+		if !role.Grants(permissions, permission) {
+			code.GinResponse(c, code.PermissionDenied, permission)
+			c.Abort()
+			return
+		}
+		c.Set("permissions", permissions)
+		c.Next()
+	}
+}
+
+// requestPermissions responds and aborts on error.
+func requestPermissions(c *gin.Context) ([]role.Permission, error) {
+	if value, exist := c.Get("permissions"); exist {
+		permissions, ok := value.([]role.Permission)
+		if !ok {
+			code.GinResponse(c, code.InternalError)
+			logrus.Error("permissions is invalid")
+			c.Abort()
+			return nil, code.InternalError
+		}
+		return permissions, nil
+	}
+
+	claims, err := claimsFromContext(c)
+	if err != nil {
+		code.GinResponse(c, code.InternalError)
+		logrus.Error(err)
+		c.Abort()
+		return nil, err
+	}
+	roles, err := rolesFromClaims(claims)
+	if err != nil {
+		code.GinResponse(c, code.InvalidToken, err.Error())
+		c.Abort()
+		return nil, err
+	}
+	return role.Permissions(roles), nil
+}
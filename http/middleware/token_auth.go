@@ -2,26 +2,84 @@ package middleware
 
 import (
 	"account-operator/code"
+	"account-operator/revocation"
 	"account-operator/token"
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"strings"
 )
 
-func ParseToken() gin.HandlerFunc {
+const (
+	AuthMethodCookie = "cookie"
+	AuthMethodBearer = "bearer"
+)
+
+const (
+	defaultTokenCookieName = "token"
+	bearerPrefix           = "Bearer "
+)
+
+// ParseToken reads the token from the sources listed in token.sources, in that order
+// ("bearer" for the Authorization header and "cookie" for the token.cookieName cookie).
+// Tokens revoked in revoked are rejected.
+func ParseToken(revoked revocation.Checker) gin.HandlerFunc {
+	cookieName := viper.GetString("token.cookieName")
+	if cookieName == "" {
+		cookieName = defaultTokenCookieName
+	}
+	sources := viper.GetStringSlice("token.sources")
+	if len(sources) == 0 {
+		sources = []string{AuthMethodBearer, AuthMethodCookie}
+	}
+
 	return func(c *gin.Context) {
-		tokenString, err := c.Cookie("token")
-		if err != nil {
+		var tokenString, authMethod string
+		for _, source := range sources {
+			switch source {
+			case AuthMethodBearer:
+				header := c.GetHeader("Authorization")
+				if header == "" {
+					continue
+				}
+				if !strings.HasPrefix(header, bearerPrefix) {
+					code.GinResponse(c, code.InvalidToken, "Authorization header is not a bearer token")
+					c.Abort()
+					return
+				}
+				tokenString = strings.TrimSpace(strings.TrimPrefix(header, bearerPrefix))
+			case AuthMethodCookie:
+				cookie, err := c.Cookie(cookieName)
+				if err != nil {
+					continue
+				}
+				tokenString = cookie
+			}
+			if tokenString != "" {
+				authMethod = source
+				break
+			}
+		}
+		if tokenString == "" {
 			code.GinResponse(c, code.TokenNotfound)
 			c.Abort()
 			return
 		}
+
 		claims, err := token.VerifyToken(tokenString)
 		if err != nil {
-			code.GinResponse(c, code.InvalidToken, err.Error())
+			code.GinResponse(c, err)
+			c.Abort()
+			return
+		}
+		err = revoked.Check(claims)
+		if err != nil {
+			code.GinResponse(c, err)
 			c.Abort()
 			return
 		}
 		//logrus.Debug("claims: ", claims)
 		c.Set("jwt_claims", claims)
+		c.Set("auth_method", authMethod)
 		c.Next()
 	}
 }
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"account-operator/role"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setConfig(t *testing.T, key string, value interface{}) {
+	t.Helper()
+	viper.Set(key, value)
+	t.Cleanup(func() {
+		viper.Set(key, nil)
+	})
+}
+
+func serveRequest(req *http.Request) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Any("/", ParseToken(noRevocations{}), CSRFProtect(), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("auth_method"))
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestParseTokenSources(t *testing.T) {
+	key := newSigningKey(t)
+	valid := signToken(t, key, jwt.MapClaims{"roles": role.Admin})
+
+	t.Run("Bearer", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+valid)
+		w := serveRequest(req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, AuthMethodBearer, w.Body.String())
+	})
+
+	t.Run("NotBearer", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+		assert.Equal(t, http.StatusUnauthorized, serveRequest(req).Code)
+	})
+
+	t.Run("BearerTakesPrecedence", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+valid)
+		req.AddCookie(&http.Cookie{Name: "token", Value: "garbage"})
+		assert.Equal(t, http.StatusOK, serveRequest(req).Code)
+	})
+
+	t.Run("CookieTakesPrecedence", func(t *testing.T) {
+		setConfig(t, "token.sources", []string{AuthMethodCookie, AuthMethodBearer})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+valid)
+		req.AddCookie(&http.Cookie{Name: "token", Value: "garbage"})
+		assert.Equal(t, http.StatusUnauthorized, serveRequest(req).Code)
+	})
+
+	t.Run("BearerDisabled", func(t *testing.T) {
+		setConfig(t, "token.sources", []string{AuthMethodCookie})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+valid)
+		assert.Equal(t, http.StatusUnauthorized, serveRequest(req).Code)
+	})
+
+	t.Run("CustomCookieName", func(t *testing.T) {
+		setConfig(t, "token.cookieName", "session")
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: valid})
+		w := serveRequest(req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, AuthMethodCookie, w.Body.String())
+	})
+}
+
+func TestCSRFProtect(t *testing.T) {
+	key := newSigningKey(t)
+	valid := signToken(t, key, jwt.MapClaims{"roles": role.Admin})
+
+	cookieRequest := func(method string) *http.Request {
+		req := httptest.NewRequest(method, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "token", Value: valid})
+		return req
+	}
+
+	t.Run("SafeMethod", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serveRequest(cookieRequest(http.MethodGet)).Code)
+	})
+
+	t.Run("MissingToken", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serveRequest(cookieRequest(http.MethodPost)).Code)
+	})
+
+	t.Run("MismatchedToken", func(t *testing.T) {
+		req := cookieRequest(http.MethodPost)
+		req.AddCookie(&http.Cookie{Name: "csrf_token", Value: "a"})
+		req.Header.Set("X-CSRF-Token", "b")
+		assert.Equal(t, http.StatusForbidden, serveRequest(req).Code)
+	})
+
+	t.Run("DoubleSubmit", func(t *testing.T) {
+		req := cookieRequest(http.MethodPost)
+		req.AddCookie(&http.Cookie{Name: "csrf_token", Value: "a"})
+		req.Header.Set("X-CSRF-Token", "a")
+		assert.Equal(t, http.StatusOK, serveRequest(req).Code)
+	})
+
+	t.Run("BearerIsExempt", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+valid)
+		assert.Equal(t, http.StatusOK, serveRequest(req).Code)
+	})
+
+	t.Run("OriginMode", func(t *testing.T) {
+		setConfig(t, "csrf.mode", CSRFModeOrigin)
+		setConfig(t, "cors.allow_origins", []string{"https://app.example.com"})
+
+		req := cookieRequest(http.MethodPost)
+		req.Header.Set("Origin", "https://app.example.com")
+		assert.Equal(t, http.StatusOK, serveRequest(req).Code)
+
+		req = cookieRequest(http.MethodPost)
+		req.Header.Set("Referer", "https://app.example.com/trade")
+		assert.Equal(t, http.StatusOK, serveRequest(req).Code)
+
+		req = cookieRequest(http.MethodPost)
+		req.Header.Set("Origin", "https://evil.example.com")
+		assert.Equal(t, http.StatusForbidden, serveRequest(req).Code)
+
+		assert.Equal(t, http.StatusForbidden, serveRequest(cookieRequest(http.MethodPost)).Code)
+	})
+}
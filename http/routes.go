@@ -2,14 +2,38 @@ package http
 
 import (
 	"account-operator/account"
+	"account-operator/apikey"
+	"account-operator/audit"
 	"account-operator/http/handlers"
 	"account-operator/http/middleware"
+	"account-operator/revocation"
 	"account-operator/role"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
 
-func SetupRouter(operator account.Operator) (*gin.Engine, error) {
+// route describes an authenticated endpoint. The middleware chain is derived from it.
+type route struct {
+	method     string
+	path       string
+	permission role.Permission
+	// userScoped routes act on behalf of the user_id of the token
+	userScoped bool
+	handler    gin.HandlerFunc
+}
+
+func registerRoutes(group *gin.RouterGroup, apiKeys apikey.Store, revocations revocation.Store, auditLog audit.Store, routes []route) {
+	for _, rt := range routes {
+		handlersChain := []gin.HandlerFunc{middleware.Authenticate(apiKeys, revocations), middleware.CSRFProtect(), middleware.RequirePermission(rt.permission)}
+		if rt.userScoped {
+			handlersChain = append(handlersChain, middleware.ParseUserID())
+		}
+		handlersChain = append(handlersChain, middleware.AuditStart(auditLog), rt.handler)
+		group.Handle(rt.method, rt.path, handlersChain...)
+	}
+}
+
+func SetupRouter(operator account.Operator, apiKeys apikey.Store, revocations revocation.Store, auditLog audit.Store) (*gin.Engine, error) {
 	r := gin.Default()
 	err := r.SetTrustedProxies(nil)
 	if err != nil {
@@ -18,24 +42,50 @@ func SetupRouter(operator account.Operator) (*gin.Engine, error) {
 
 	// Configure CORS
 	r.Use(middleware.Cors())
+	r.Use(middleware.Audit(auditLog))
 
 	r.GET("/health", func(c *gin.Context) {
 		c.Status(http.StatusOK)
 	})
+	r.GET("/csrf", middleware.IssueCSRFToken())
 
-	accountGroup := r.Group("/account")
-	{
-		accountGroup.POST("/new", middleware.ParseToken(), middleware.CheckTokenRole(role.Admin), middleware.ParseUserID(), handlers.NewAccount(operator))
-		accountGroup.GET("/list", middleware.ParseToken(), middleware.CheckTokenRole(role.Admin), middleware.ParseUserID(), handlers.ListAccount(operator))
-	}
+	registerRoutes(r.Group("/account"), apiKeys, revocations, auditLog, []route{
+		{http.MethodPost, "/new", role.AccountWrite, true, handlers.NewAccount(operator)},
+		{http.MethodGet, "/list", role.AccountRead, true, handlers.ListAccount(operator)},
+		{http.MethodGet, "/portfolio", role.AccountRead, true, handlers.Portfolio(operator)},
+		{http.MethodGet, "/pnl", role.AccountRead, true, handlers.UserPnL(operator)},
+		{http.MethodGet, "/:id/pnl", role.AccountRead, true, handlers.AccountPnL(operator)},
+		{http.MethodGet, "/:id/statement", role.AccountRead, true, handlers.Statement(operator)},
+		{http.MethodPost, "/freeze", role.AdminAccounts, true, handlers.Freeze(operator)},
+		{http.MethodPost, "/unfreeze", role.AdminAccounts, true, handlers.Unfreeze(operator)},
+		{http.MethodPost, "/restore", role.AdminAccounts, true, handlers.Restore(operator)},
+		{http.MethodPatch, "/:id", role.AccountWrite, true, handlers.UpdateAccount(operator)},
+		{http.MethodPost, "/apikey/new", role.AccountWrite, true, handlers.CreateAPIKey(apiKeys)},
+		{http.MethodGet, "/apikey/list", role.AccountRead, true, handlers.ListAPIKeys(apiKeys)},
+		{http.MethodPost, "/apikey/revoke", role.AccountWrite, true, handlers.RevokeAPIKey(apiKeys)},
+	})
 
-	tradeGroup := r.Group("/trade")
-	{
-		tradeGroup.POST("/withdraw", middleware.ParseToken(), middleware.CheckTokenRole(role.Admin), middleware.ParseUserID(), handlers.Withdraw(operator))
-		tradeGroup.POST("/deposit", middleware.ParseToken(), middleware.CheckTokenRole(role.Admin), middleware.ParseUserID(), handlers.Deposit(operator))
-		tradeGroup.POST("/delete", middleware.ParseToken(), middleware.CheckTokenRole(role.Admin), middleware.ParseUserID(), handlers.Delete(operator))
-		tradeGroup.POST("/order", middleware.ParseToken(), middleware.CheckTokenRole(role.Admin), middleware.ParseUserID(), handlers.TradeOrder(operator))
-	}
+	registerRoutes(r.Group("/trade"), apiKeys, revocations, auditLog, []route{
+		{http.MethodPost, "/withdraw", role.FundsWithdraw, true, handlers.Withdraw(operator)},
+		{http.MethodPost, "/deposit", role.FundsDeposit, true, handlers.Deposit(operator)},
+		{http.MethodPost, "/delete", role.AccountWrite, true, handlers.Delete(operator)},
+		{http.MethodPost, "/order", role.TradeExecute, true, handlers.TradeOrder(operator)},
+		{http.MethodGet, "/withdrawal/pending", role.AdminWithdrawals, false, handlers.ListPendingWithdrawals(operator)},
+		{http.MethodPost, "/withdrawal/approve", role.AdminWithdrawals, true, handlers.ApproveWithdrawal(operator)},
+		{http.MethodPost, "/withdrawal/reject", role.AdminWithdrawals, true, handlers.RejectWithdrawal(operator)},
+	})
+
+	registerRoutes(r.Group("/admin"), apiKeys, revocations, auditLog, []route{
+		{http.MethodGet, "/symbol/list", role.AdminSymbols, false, handlers.ListSymbols(operator)},
+		{http.MethodPost, "/symbol/subscribe", role.AdminSymbols, false, handlers.SubscribeSymbol(operator)},
+		{http.MethodPost, "/symbol/unsubscribe", role.AdminSymbols, false, handlers.UnsubscribeSymbol(operator)},
+		{http.MethodGet, "/price/stats", role.AdminPrice, false, handlers.PriceStats()},
+		{http.MethodPost, "/token/revoke", role.AdminTokens, true, handlers.RevokeToken(revocations)},
+		{http.MethodPost, "/user/revoke", role.AdminTokens, true, handlers.RevokeUserSessions(revocations)},
+		{http.MethodPost, "/limits/tier", role.AdminLimits, true, handlers.SetUserTier(operator)},
+		{http.MethodGet, "/audit", role.AdminAudit, false, handlers.QueryAudit(auditLog)},
+		{http.MethodGet, "/audit/verify", role.AdminAudit, false, handlers.VerifyAudit(auditLog)},
+	})
 
 	return r, nil
 }
@@ -2,19 +2,22 @@ package main
 
 import (
 	"account-operator/account"
+	"account-operator/apikey"
+	"account-operator/audit"
 	"account-operator/config"
 	"account-operator/http"
 	"account-operator/log"
 	"account-operator/market"
+	"account-operator/outbox"
 	"account-operator/postgresql"
 	"account-operator/price"
 	"account-operator/quit"
 	"account-operator/rabbitmq"
+	"account-operator/revocation"
 	"account-operator/token"
 	"context"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 	"time"
 )
 
@@ -31,7 +34,12 @@ func main() {
 		return
 	}
 	log.InitLogger()
-	token.InitVerifyKey(viper.GetString("token.publicKeyPath"))
+	err = token.InitVerifyKey()
+	if err != nil {
+		logrus.Panicf("Failed to initialize verify key: %v", err)
+		return
+	}
+	defer token.CloseVerifyKey()
 
 	ctx, cancel := context.WithTimeoutCause(context.Background(), InitializationTimeout, fmt.Errorf("initilization timeout"))
 	defer cancel()
@@ -49,21 +57,51 @@ func main() {
 	}
 	defer rabbitmq.CloseRabbitMQ()
 
-	receiverInst := price.NewPriceReceiver()
-	msgs, err := receiverInst.Start(ctx)
+	relayInst := outbox.NewRelay()
+	relayInst.Start()
+	defer relayInst.Close()
+
+	sourceInst, err := price.NewSource()
 	if err != nil {
-		logrus.Panicf("Failed to start price receiver: %v", err)
+		logrus.Panicf("Failed to create price source: %v", err)
 		return
 	}
-	defer receiverInst.Close()
+	feeds, err := sourceInst.Start(ctx)
+	if err != nil {
+		logrus.Panicf("Failed to start price source: %v", err)
+		return
+	}
+	defer sourceInst.Close()
 
-	marketInst := market.NewMarket()
+	marketInst, err := market.NewMarket()
+	if err != nil {
+		logrus.Panicf("Failed to create market: %v", err)
+		return
+	}
 
-	operatorInst := account.NewOperator(msgs, marketInst)
+	operatorInst, err := account.NewOperator(sourceInst, feeds, marketInst)
+	if err != nil {
+		logrus.Panicf("Failed to create operator: %v", err)
+		return
+	}
 	operatorInst.Start()
 	defer operatorInst.Close()
 
-	r, err := http.SetupRouter(operatorInst)
+	apiKeyStore, err := apikey.NewStore()
+	if err != nil {
+		logrus.Panicf("Failed to create api key store: %v", err)
+		return
+	}
+
+	revocationStore := revocation.NewStore()
+	err = revocationStore.Start()
+	if err != nil {
+		logrus.Panicf("Failed to start revocation store: %v", err)
+		return
+	}
+	defer revocationStore.Close()
+
+	r, err := http.SetupRouter(operatorInst, apiKeyStore, revocationStore, audit.NewStore())
 	if err != nil {
 		logrus.Panicf("Failed to setup router: %v", err)
 		return
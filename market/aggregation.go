@@ -0,0 +1,149 @@
+package market
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// LastMethod uses the price of the latest accepted tick
+	LastMethod = "last"
+	// MedianMethod uses the median price of the ticks in the window
+	MedianMethod = "median"
+	// VWAPMethod uses the volume-weighted average price of the ticks in the window
+	VWAPMethod = "vwap"
+)
+
+// AggregationPolicy decides how the reference price of a symbol is computed from its ticks.
+type AggregationPolicy struct {
+	Method string        `mapstructure:"method"`
+	Window time.Duration `mapstructure:"window"`
+	// MaxDeviation is the largest relative distance from the median of the window a tick may have,
+	// e.g. 0.05 for 5%. Zero disables outlier rejection, which also requires a window.
+	MaxDeviation float64 `mapstructure:"maxDeviation"`
+	// MinTicks is how many ticks the window must hold before any is rejected as an outlier,
+	// so that a bad first tick cannot become the reference. Defaults to defaultMinTicks.
+	MinTicks int `mapstructure:"minTicks"`
+}
+
+const defaultMinTicks = 3
+
+type aggregationConfig struct {
+	Default AggregationPolicy            `mapstructure:"default"`
+	Symbols map[string]AggregationPolicy `mapstructure:"symbols"`
+}
+
+// loadPolicies reads market.aggregation, keyed by upper-case symbol since viper lower-cases keys.
+func loadPolicies() (defaultPolicy AggregationPolicy, symbolPolicies map[string]AggregationPolicy, err error) {
+	var config aggregationConfig
+	err = viper.UnmarshalKey("market.aggregation", &config)
+	if err != nil {
+		return AggregationPolicy{}, nil, fmt.Errorf("failed to read market.aggregation: %w", err)
+	}
+
+	defaultPolicy, err = config.Default.normalize()
+	if err != nil {
+		return AggregationPolicy{}, nil, err
+	}
+	symbolPolicies = make(map[string]AggregationPolicy, len(config.Symbols))
+	for symbol, policy := range config.Symbols {
+		symbolPolicies[strings.ToUpper(symbol)], err = policy.normalize()
+		if err != nil {
+			return AggregationPolicy{}, nil, fmt.Errorf("symbol %s: %w", symbol, err)
+		}
+	}
+	return defaultPolicy, symbolPolicies, nil
+}
+
+func (p AggregationPolicy) normalize() (AggregationPolicy, error) {
+	switch p.Method {
+	case "":
+		p.Method = LastMethod
+	case LastMethod, MedianMethod, VWAPMethod:
+	default:
+		return p, fmt.Errorf("unknown aggregation method: %s", p.Method)
+	}
+	if p.Method != LastMethod && p.Window <= 0 {
+		return p, fmt.Errorf("aggregation method %s requires a window", p.Method)
+	}
+	if p.MaxDeviation < 0 {
+		return p, fmt.Errorf("invalid max deviation: %f", p.MaxDeviation)
+	}
+	if p.MinTicks < 0 {
+		return p, fmt.Errorf("invalid min ticks: %d", p.MinTicks)
+	}
+	return p, nil
+}
+
+type windowTick struct {
+	price    *big.Rat
+	quantity *big.Rat
+	// time in milliseconds
+	time int64
+}
+
+// aggregate computes the reference price of ticks according to method.
+// ticks must not be empty.
+func aggregate(method string, ticks []windowTick) *big.Rat {
+	switch method {
+	case MedianMethod:
+		return median(ticks)
+	case VWAPMethod:
+		return vwap(ticks)
+	default:
+		return ticks[len(ticks)-1].price
+	}
+}
+
+func median(ticks []windowTick) *big.Rat {
+	prices := make([]*big.Rat, len(ticks))
+	for i, tick := range ticks {
+		prices[i] = tick.price
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].Cmp(prices[j]) < 0
+	})
+
+	middle := len(prices) / 2
+	if len(prices)%2 == 1 {
+		return prices[middle]
+	}
+	var sum big.Rat
+	sum.Add(prices[middle-1], prices[middle])
+	return sum.Quo(&sum, big.NewRat(2, 1))
+}
+
+// vwap falls back to the median when the ticks carry no volume.
+func vwap(ticks []windowTick) *big.Rat {
+	var notional, volume big.Rat
+	for _, tick := range ticks {
+		if tick.quantity == nil {
+			continue
+		}
+		var tickNotional big.Rat
+		tickNotional.Mul(tick.price, tick.quantity)
+		notional.Add(&notional, &tickNotional)
+		volume.Add(&volume, tick.quantity)
+	}
+	if volume.Sign() == 0 {
+		return median(ticks)
+	}
+	return notional.Quo(&notional, &volume)
+}
+
+// deviation returns |value - reference| / reference.
+func deviation(value *big.Rat, reference *big.Rat) float64 {
+	if reference.Sign() == 0 {
+		return 0
+	}
+	var diff big.Rat
+	diff.Sub(value, reference)
+	diff.Abs(&diff)
+	diff.Quo(&diff, reference)
+	result, _ := diff.Float64()
+	return result
+}
@@ -3,61 +3,365 @@ package market
 import (
 	"errors"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"go.uber.org/atomic"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Market interface {
-	UpdatePrice(symbol string, price string)
-	MarketOrder(symbol string, callBAck func(string)) error
+	// UpdatePrice adds quote to the reference price of symbol unless it is older than the last
+	// quote of the same source or an outlier. It reports whether the quote was accepted.
+	UpdatePrice(symbol string, quote Quote) bool
+	// Rate returns the price of one base in quote, derived through an intermediate currency
+	// when the pair itself is not quoted
+	Rate(baseCurrency string, quoteCurrency string) (Rate, error)
+	// MarketOrder calls callBack with the rate of baseCurrency in quoteCurrency and returns its error
+	MarketOrder(baseCurrency string, quoteCurrency string, callBack func(Rate) error) error
+	// RemoveSymbol forgets the price of symbol so that no order is placed at a stale price
+	RemoveSymbol(symbol string)
+	// DroppedTicks returns how many quotes of symbol were ignored as duplicated or out of order
+	DroppedTicks(symbol string) uint64
+	// RejectedTicks returns how many quotes of symbol were ignored as outliers
+	RejectedTicks(symbol string) uint64
 }
 
-func NewMarket() Market {
+// Quote is a trade price along with the trade ID and time used to order it.
+type Quote struct {
+	// Source names the exchange or publisher of the trade, trade IDs are only compared within a source
+	Source   string
+	Price    string
+	Quantity string
+	TradeID  int64
+	// TradeTime is the trade time in milliseconds
+	TradeTime int64
+}
+
+// Leg is a quoted symbol a rate is derived from.
+type Leg struct {
+	Symbol string `json:"symbol"`
+	Price  string `json:"price"`
+	// Inverse is set when the leg converts from the quote to the base currency of Symbol
+	Inverse bool `json:"inverse,omitempty"`
+}
+
+// rate returns how much of the target currency one unit of the source currency of the leg is worth.
+// Prices are positive decimals, so the rate is exact.
+func (l Leg) rate() *big.Rat {
+	price, _ := new(big.Rat).SetString(l.Price)
+	if !l.Inverse {
+		return price
+	}
+	return price.Inv(price)
+}
+
+// rateSignificantDigits is how many significant digits derived rates keep, well beyond the
+// 8 decimals amounts are rounded to, so low-priced currencies keep their precision.
+const rateSignificantDigits = 20
+
+// formatRate formats rate as a decimal with rateSignificantDigits significant digits.
+func formatRate(rate *big.Rat) string {
+	// The decimal exponent of rate tells how many decimals make up the significant digits
+	exponent := 0
+	if rate.Sign() != 0 {
+		scientific := new(big.Float).SetPrec(128).SetRat(rate).Text('e', 0)
+		exponent, _ = strconv.Atoi(scientific[strings.IndexByte(scientific, 'e')+1:])
+	}
+	decimals := rateSignificantDigits - 1 - exponent
+	if decimals < 0 {
+		decimals = 0
+	}
+	formatted := rate.FloatString(decimals)
+	if strings.Contains(formatted, ".") {
+		formatted = strings.TrimRight(strings.TrimRight(formatted, "0"), ".")
+	}
+	return formatted
+}
+
+// Rate is the price of one unit of a base currency in a quote currency.
+type Rate struct {
+	Price string
+	// Route lists the quoted symbols in conversion order, it is empty when both currencies are the same
+	Route []Leg
+}
+
+type Config struct {
+	DefaultPolicy AggregationPolicy
+	// SymbolPolicies is keyed by upper-case symbol
+	SymbolPolicies map[string]AggregationPolicy
+	// CrossCurrencies are tried in order as intermediate currency when a pair is not quoted
+	CrossCurrencies []string
+}
+
+// NewMarket creates a market configured by market.aggregation and market.crossCurrencies.
+func NewMarket() (Market, error) {
+	defaultPolicy, symbolPolicies, err := loadPolicies()
+	if err != nil {
+		return nil, err
+	}
+	return NewMarketWithConfig(Config{
+		DefaultPolicy:   defaultPolicy,
+		SymbolPolicies:  symbolPolicies,
+		CrossCurrencies: viper.GetStringSlice("market.crossCurrencies"),
+	}), nil
+}
+
+func NewMarketWithConfig(config Config) Market {
 	return &market{
-		tradePairs: make(map[string]*price),
+		tradePairs:      make(map[string]*price),
+		defaultPolicy:   config.DefaultPolicy,
+		symbolPolicies:  config.SymbolPolicies,
+		crossCurrencies: config.CrossCurrencies,
 	}
 }
 
+type sourceState struct {
+	lastTradeID   int64
+	lastTradeTime int64
+}
+
 type price struct {
-	currentPrice atomic.String
+	policy AggregationPolicy
+
+	lock         sync.RWMutex
+	currentPrice string
+	// source -> order of its last accepted trade
+	sources map[string]*sourceState
+	// ticks within the policy window, in time order
+	window   []windowTick
+	dropped  atomic.Uint64
+	rejected atomic.Uint64
+}
+
+func (p *price) UpdatePrice(quote Quote) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	state, exists := p.sources[quote.Source]
+	if !exists {
+		state = &sourceState{}
+		p.sources[quote.Source] = state
+	}
+	if state.isRegression(quote) {
+		p.dropped.Inc()
+		return false
+	}
+
+	tickPrice, ok := new(big.Rat).SetString(quote.Price)
+	if !ok || tickPrice.Sign() <= 0 {
+		p.rejected.Inc()
+		return false
+	}
+	var tickQuantity *big.Rat
+	if quote.Quantity != "" {
+		tickQuantity, _ = new(big.Rat).SetString(quote.Quantity)
+	}
+	tickTime := quote.TradeTime
+	if tickTime == 0 {
+		tickTime = time.Now().UnixMilli()
+	}
+
+	if p.policy.Window > 0 {
+		p.pruneWindow(tickTime)
+		if p.isOutlier(tickPrice) {
+			p.rejected.Inc()
+			return false
+		}
+		p.insertTick(windowTick{price: tickPrice, quantity: tickQuantity, time: tickTime})
+	}
+
+	state.lastTradeID = quote.TradeID
+	state.lastTradeTime = quote.TradeTime
+	if p.policy.Method == LastMethod || len(p.window) == 0 {
+		p.currentPrice = quote.Price
+	} else {
+		p.currentPrice = aggregate(p.policy.Method, p.window).FloatString(8)
+	}
+	return true
+}
+
+// isRegression reports whether quote is a redelivery or older than the last trade of its source.
+// A zero trade ID means the source does not number its trades, so only the time is compared.
+func (s *sourceState) isRegression(quote Quote) bool {
+	if quote.TradeTime < s.lastTradeTime {
+		return true
+	}
+	return quote.TradeID != 0 && quote.TradeID <= s.lastTradeID
+}
+
+// pruneWindow drops the ticks older than the window ending at now, or at the latest tick if a
+// source is behind. It must be called with lock held.
+func (p *price) pruneWindow(now int64) {
+	if len(p.window) > 0 && p.window[len(p.window)-1].time > now {
+		now = p.window[len(p.window)-1].time
+	}
+	windowStart := now - p.policy.Window.Milliseconds()
+	i := 0
+	for i < len(p.window) && p.window[i].time < windowStart {
+		i++
+	}
+	p.window = p.window[i:]
 }
 
-func (p *price) UpdatePrice(currentPrice string) {
-	p.currentPrice.Store(currentPrice)
+// insertTick adds tick to the window in time order, since the ticks of different sources may
+// arrive out of order. A tick already outside the window is left out. It must be called with lock held.
+func (p *price) insertTick(tick windowTick) {
+	if len(p.window) > 0 && tick.time < p.window[len(p.window)-1].time-p.policy.Window.Milliseconds() {
+		return
+	}
+	i := sort.Search(len(p.window), func(i int) bool {
+		return p.window[i].time > tick.time
+	})
+	p.window = append(p.window, windowTick{})
+	copy(p.window[i+1:], p.window[i:])
+	p.window[i] = tick
+}
+
+// isOutlier compares tickPrice to the median of the current window, whatever the aggregation
+// method, so that a single bad tick in the window does not get every good one rejected.
+// Nothing is rejected until the window holds MinTicks ticks. It must be called with lock held.
+func (p *price) isOutlier(tickPrice *big.Rat) bool {
+	minTicks := p.policy.MinTicks
+	if minTicks <= 0 {
+		minTicks = defaultMinTicks
+	}
+	if p.policy.MaxDeviation <= 0 || len(p.window) < minTicks {
+		return false
+	}
+	return deviation(tickPrice, median(p.window)) > p.policy.MaxDeviation
 }
 
 func (p *price) CurrentPrice() string {
-	return p.currentPrice.Load()
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.currentPrice
 }
 
-func newPrice() *price {
-	return &price{}
+func newPrice(policy AggregationPolicy) *price {
+	return &price{
+		policy:  policy,
+		sources: make(map[string]*sourceState),
+	}
 }
 
 type market struct {
 	// symbol -> price
 	tradePairs     map[string]*price
 	tradePairsLock sync.RWMutex
+
+	defaultPolicy   AggregationPolicy
+	symbolPolicies  map[string]AggregationPolicy
+	crossCurrencies []string
 }
 
 var ErrSymbolNotFound = errors.New("symbol not found")
 
-func (m *market) MarketOrder(symbol string, callBAck func(string)) error {
+func (m *market) MarketOrder(baseCurrency string, quoteCurrency string, callBack func(Rate) error) error {
+	rate, err := m.Rate(baseCurrency, quoteCurrency)
+	if err != nil {
+		return err
+	}
+	return callBack(rate)
+}
+
+func (m *market) Rate(baseCurrency string, quoteCurrency string) (Rate, error) {
+	if baseCurrency == quoteCurrency {
+		return Rate{Price: "1"}, nil
+	}
+	if leg, exists := m.leg(baseCurrency, quoteCurrency); exists {
+		if !leg.Inverse {
+			return Rate{Price: leg.Price, Route: []Leg{leg}}, nil
+		}
+		return Rate{Price: formatRate(leg.rate()), Route: []Leg{leg}}, nil
+	}
+
+	for _, intermediate := range m.crossCurrencies {
+		if intermediate == baseCurrency || intermediate == quoteCurrency {
+			continue
+		}
+		first, exists := m.leg(baseCurrency, intermediate)
+		if !exists {
+			continue
+		}
+		second, exists := m.leg(intermediate, quoteCurrency)
+		if !exists {
+			continue
+		}
+		var crossPrice big.Rat
+		crossPrice.Mul(first.rate(), second.rate())
+		return Rate{Price: formatRate(&crossPrice), Route: []Leg{first, second}}, nil
+	}
+	return Rate{}, ErrSymbolNotFound
+}
+
+// leg converts baseCurrency to quoteCurrency with the baseCurrency+quoteCurrency symbol,
+// or with the inverse of quoteCurrency+baseCurrency when only the reverse pair is quoted.
+func (m *market) leg(baseCurrency string, quoteCurrency string) (Leg, bool) {
+	if currentPrice, exists := m.currentPrice(baseCurrency + quoteCurrency); exists {
+		return Leg{Symbol: baseCurrency + quoteCurrency, Price: currentPrice}, true
+	}
+	if currentPrice, exists := m.currentPrice(quoteCurrency + baseCurrency); exists {
+		return Leg{Symbol: quoteCurrency + baseCurrency, Price: currentPrice, Inverse: true}, true
+	}
+	return Leg{}, false
+}
+
+func (m *market) currentPrice(symbol string) (string, bool) {
 	m.tradePairsLock.RLock()
 	priceInst, exists := m.tradePairs[symbol]
 	m.tradePairsLock.RUnlock()
 	if !exists {
-		return ErrSymbolNotFound
+		return "", false
 	}
-
-	callBAck(priceInst.CurrentPrice())
-	return nil
+	currentPrice := priceInst.CurrentPrice()
+	return currentPrice, currentPrice != ""
 }
 
-func (m *market) UpdatePrice(symbol string, currentPrice string) {
-	logrus.Infof("Update price for symbol %s: %s", symbol, currentPrice)
+func (m *market) UpdatePrice(symbol string, quote Quote) bool {
 	priceInst := m.getOrCreatePrice(symbol)
-	priceInst.UpdatePrice(currentPrice)
+	if !priceInst.UpdatePrice(quote) {
+		logrus.Debugf("Ignore price for symbol %s from %s: %s (trade %d at %d)", symbol, quote.Source, quote.Price, quote.TradeID, quote.TradeTime)
+		return false
+	}
+	logrus.Infof("Update price for symbol %s: %s", symbol, quote.Price)
+	return true
+}
+
+func (m *market) DroppedTicks(symbol string) uint64 {
+	m.tradePairsLock.RLock()
+	priceInst, exists := m.tradePairs[symbol]
+	m.tradePairsLock.RUnlock()
+	if !exists {
+		return 0
+	}
+	return priceInst.dropped.Load()
+}
+
+func (m *market) RejectedTicks(symbol string) uint64 {
+	m.tradePairsLock.RLock()
+	priceInst, exists := m.tradePairs[symbol]
+	m.tradePairsLock.RUnlock()
+	if !exists {
+		return 0
+	}
+	return priceInst.rejected.Load()
+}
+
+func (m *market) policyOf(symbol string) AggregationPolicy {
+	if policy, exists := m.symbolPolicies[strings.ToUpper(symbol)]; exists {
+		return policy
+	}
+	return m.defaultPolicy
+}
+
+func (m *market) RemoveSymbol(symbol string) {
+	m.tradePairsLock.Lock()
+	defer m.tradePairsLock.Unlock()
+	delete(m.tradePairs, symbol)
 }
 
 func (m *market) getOrCreatePrice(symbol string) (priceInst *price) {
@@ -76,7 +380,7 @@ func (m *market) getOrCreatePrice(symbol string) (priceInst *price) {
 		// Another goroutine has created the price instance
 		return
 	}
-	priceInst = newPrice()
+	priceInst = newPrice(m.policyOf(symbol))
 	m.tradePairs[symbol] = priceInst
 	return
 }
@@ -0,0 +1,394 @@
+package market
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func currentPrice(t *testing.T, m Market, symbol string) string {
+	t.Helper()
+	m.(*market).tradePairsLock.RLock()
+	priceInst, exists := m.(*market).tradePairs[symbol]
+	m.(*market).tradePairsLock.RUnlock()
+	assert.True(t, exists)
+	return priceInst.CurrentPrice()
+}
+
+func TestUpdatePrice(t *testing.T) {
+	tests := []struct {
+		name            string
+		quotes          []Quote
+		expectedPrice   string
+		expectedDropped uint64
+	}{
+		{
+			name: "InOrder",
+			quotes: []Quote{
+				{Price: "1", TradeID: 1, TradeTime: 100},
+				{Price: "2", TradeID: 2, TradeTime: 200},
+			},
+			expectedPrice:   "2",
+			expectedDropped: 0,
+		},
+		{
+			name: "Duplicate",
+			quotes: []Quote{
+				{Price: "1", TradeID: 1, TradeTime: 100},
+				{Price: "1", TradeID: 1, TradeTime: 100},
+			},
+			expectedPrice:   "1",
+			expectedDropped: 1,
+		},
+		{
+			name: "OlderTradeID",
+			quotes: []Quote{
+				{Price: "2", TradeID: 2, TradeTime: 100},
+				{Price: "1", TradeID: 1, TradeTime: 100},
+			},
+			expectedPrice:   "2",
+			expectedDropped: 1,
+		},
+		{
+			name: "OlderTradeTime",
+			quotes: []Quote{
+				{Price: "2", TradeID: 1, TradeTime: 200},
+				{Price: "1", TradeID: 2, TradeTime: 100},
+			},
+			expectedPrice:   "2",
+			expectedDropped: 1,
+		},
+		{
+			name: "SameTimeWithoutTradeID",
+			quotes: []Quote{
+				{Price: "1", TradeTime: 100},
+				{Price: "2", TradeTime: 100},
+			},
+			expectedPrice:   "2",
+			expectedDropped: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMarketWithConfig(Config{DefaultPolicy: AggregationPolicy{Method: LastMethod}})
+			for _, quote := range tt.quotes {
+				m.UpdatePrice("BTCUSDT", quote)
+			}
+			assert.Equal(t, tt.expectedPrice, currentPrice(t, m, "BTCUSDT"))
+			assert.Equal(t, tt.expectedDropped, m.DroppedTicks("BTCUSDT"))
+		})
+	}
+}
+
+func TestUpdatePriceConcurrent(t *testing.T) {
+	const tickCount = 1000
+	const workerCount = 8
+
+	quotes := make([]Quote, tickCount)
+	for i := range quotes {
+		quotes[i] = Quote{
+			Price:     fmt.Sprintf("%d", i+1),
+			TradeID:   int64(i + 1),
+			TradeTime: int64(i + 1),
+		}
+	}
+	// Every tick is delivered twice, in random order
+	quotes = append(quotes, quotes...)
+	rand.Shuffle(len(quotes), func(i, j int) {
+		quotes[i], quotes[j] = quotes[j], quotes[i]
+	})
+
+	m := NewMarketWithConfig(Config{DefaultPolicy: AggregationPolicy{Method: LastMethod}})
+	var accepted sync.Map
+	var wg sync.WaitGroup
+	for w := 0; w < workerCount; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(quotes); i += workerCount {
+				if m.UpdatePrice("BTCUSDT", quotes[i]) {
+					_, duplicated := accepted.LoadOrStore(quotes[i].TradeID, struct{}{})
+					assert.False(t, duplicated, "trade %d accepted twice", quotes[i].TradeID)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	acceptedCount := 0
+	accepted.Range(func(_, _ any) bool {
+		acceptedCount++
+		return true
+	})
+	assert.Equal(t, fmt.Sprintf("%d", tickCount), currentPrice(t, m, "BTCUSDT"))
+	assert.Equal(t, uint64(len(quotes)-acceptedCount), m.DroppedTicks("BTCUSDT"))
+}
+
+func TestUpdatePriceConcurrentSymbols(t *testing.T) {
+	m := NewMarketWithConfig(Config{DefaultPolicy: AggregationPolicy{Method: LastMethod}})
+	var wg sync.WaitGroup
+	for s := 0; s < 10; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			symbol := fmt.Sprintf("SYM%d", s)
+			for i := 1; i <= 100; i++ {
+				m.UpdatePrice(symbol, Quote{Price: fmt.Sprintf("%d", i), TradeID: int64(i), TradeTime: int64(i)})
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	for s := 0; s < 10; s++ {
+		symbol := fmt.Sprintf("SYM%d", s)
+		assert.Equal(t, "100", currentPrice(t, m, symbol))
+		assert.Equal(t, uint64(0), m.DroppedTicks(symbol))
+	}
+}
+
+func TestMarketOrderSymbolNotFound(t *testing.T) {
+	m := NewMarketWithConfig(Config{DefaultPolicy: AggregationPolicy{Method: LastMethod}})
+	noop := func(Rate) error { return nil }
+	err := m.MarketOrder("BTC", "USDT", noop)
+	assert.ErrorIs(t, err, ErrSymbolNotFound)
+
+	m.UpdatePrice("BTCUSDT", Quote{Price: "1", TradeID: 1, TradeTime: 1})
+	m.RemoveSymbol("BTCUSDT")
+	err = m.MarketOrder("BTC", "USDT", noop)
+	assert.ErrorIs(t, err, ErrSymbolNotFound)
+}
+
+func TestRate(t *testing.T) {
+	m := NewMarketWithConfig(Config{
+		DefaultPolicy:   AggregationPolicy{Method: LastMethod},
+		CrossCurrencies: []string{"BTC", "USDT"},
+	})
+	m.UpdatePrice("ETHUSDT", Quote{Price: "2000", TradeTime: 1})
+	m.UpdatePrice("USDTEUR", Quote{Price: "0.9", TradeTime: 1})
+	m.UpdatePrice("SHIBUSDT", Quote{Price: "0.00001234", TradeTime: 1})
+
+	tests := []struct {
+		name          string
+		base          string
+		quote         string
+		expectedPrice string
+		expectedRoute []Leg
+		expectedErr   error
+	}{
+		{
+			name:          "Direct",
+			base:          "ETH",
+			quote:         "USDT",
+			expectedPrice: "2000",
+			expectedRoute: []Leg{{Symbol: "ETHUSDT", Price: "2000"}},
+		},
+		{
+			name:          "Cross",
+			base:          "ETH",
+			quote:         "EUR",
+			expectedPrice: "1800",
+			expectedRoute: []Leg{{Symbol: "ETHUSDT", Price: "2000"}, {Symbol: "USDTEUR", Price: "0.9"}},
+		},
+		{
+			name:          "Inverse",
+			base:          "USDT",
+			quote:         "ETH",
+			expectedPrice: "0.0005",
+			expectedRoute: []Leg{{Symbol: "ETHUSDT", Price: "2000", Inverse: true}},
+		},
+		{
+			name:          "CrossWithInverseLeg",
+			base:          "EUR",
+			quote:         "ETH",
+			expectedPrice: "0.00055555555555555555556",
+			expectedRoute: []Leg{{Symbol: "USDTEUR", Price: "0.9", Inverse: true}, {Symbol: "ETHUSDT", Price: "2000", Inverse: true}},
+		},
+		{
+			name:          "LowPricedCross",
+			base:          "SHIB",
+			quote:         "EUR",
+			expectedPrice: "0.000011106",
+			expectedRoute: []Leg{{Symbol: "SHIBUSDT", Price: "0.00001234"}, {Symbol: "USDTEUR", Price: "0.9"}},
+		},
+		{
+			name:          "SameCurrency",
+			base:          "ETH",
+			quote:         "ETH",
+			expectedPrice: "1",
+		},
+		{
+			name:        "NoRoute",
+			base:        "ETH",
+			quote:       "JPY",
+			expectedErr: ErrSymbolNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := m.Rate(tt.base, tt.quote)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedPrice, rate.Price)
+			assert.Equal(t, tt.expectedRoute, rate.Route)
+		})
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := []struct {
+		rate     string
+		expected string
+	}{
+		{rate: "1800", expected: "1800"},
+		{rate: "1/3", expected: "0.33333333333333333333"},
+		{rate: "1/63000", expected: "0.000015873015873015873016"},
+		{rate: "123456789012345678901234", expected: "123456789012345678901234"},
+		{rate: "0", expected: "0"},
+	}
+	for _, tt := range tests {
+		rate, ok := new(big.Rat).SetString(tt.rate)
+		assert.True(t, ok)
+		assert.Equal(t, tt.expected, formatRate(rate), tt.rate)
+	}
+}
+
+func TestUpdatePriceAggregation(t *testing.T) {
+	tests := []struct {
+		name             string
+		policy           AggregationPolicy
+		quotes           []Quote
+		expectedPrice    string
+		expectedRejected uint64
+	}{
+		{
+			name:   "Median",
+			policy: AggregationPolicy{Method: MedianMethod, Window: time.Second},
+			quotes: []Quote{
+				{Source: "a", Price: "100", TradeTime: 1000},
+				{Source: "b", Price: "130", TradeTime: 1001},
+				{Source: "c", Price: "110", TradeTime: 1002},
+			},
+			expectedPrice: "110.00000000",
+		},
+		{
+			name:   "VWAP",
+			policy: AggregationPolicy{Method: VWAPMethod, Window: time.Second},
+			quotes: []Quote{
+				{Source: "a", Price: "100", Quantity: "3", TradeTime: 1000},
+				{Source: "b", Price: "200", Quantity: "1", TradeTime: 1001},
+			},
+			expectedPrice: "125.00000000",
+		},
+		{
+			name:   "VWAPKeepsEightDecimals",
+			policy: AggregationPolicy{Method: VWAPMethod, Window: time.Second},
+			quotes: []Quote{
+				{Source: "a", Price: "1234567890123456.12345678", Quantity: "0.5", TradeTime: 1000},
+				{Source: "b", Price: "1234567890123456.12345679", Quantity: "0.5", TradeTime: 1001},
+			},
+			expectedPrice: "1234567890123456.12345679",
+		},
+		{
+			name:   "LateSourceKeepsWindowInOrder",
+			policy: AggregationPolicy{Method: MedianMethod, Window: time.Second},
+			quotes: []Quote{
+				{Source: "a", Price: "100", TradeTime: 1000},
+				{Source: "b", Price: "200", TradeTime: 1900},
+				// Source c is behind, its tick belongs between the two others
+				{Source: "c", Price: "300", TradeTime: 1200},
+				{Source: "b", Price: "210", TradeTime: 2300},
+			},
+			expectedPrice: "205.00000000",
+		},
+		{
+			name:   "WindowExpires",
+			policy: AggregationPolicy{Method: MedianMethod, Window: time.Second},
+			quotes: []Quote{
+				{Source: "a", Price: "100", TradeTime: 1000},
+				{Source: "a", Price: "300", TradeTime: 3000},
+			},
+			expectedPrice: "300.00000000",
+		},
+		{
+			name:   "OutlierRejected",
+			policy: AggregationPolicy{Method: MedianMethod, Window: time.Second, MaxDeviation: 0.05},
+			quotes: []Quote{
+				{Source: "a", Price: "100", TradeTime: 1000},
+				{Source: "b", Price: "101", TradeTime: 1001},
+				{Source: "d", Price: "102", TradeTime: 1002},
+				{Source: "c", Price: "150", TradeTime: 1003},
+			},
+			expectedPrice:    "101.00000000",
+			expectedRejected: 1,
+		},
+		{
+			name:   "BadFirstTickDoesNotFreezePrice",
+			policy: AggregationPolicy{Method: MedianMethod, Window: time.Second, MaxDeviation: 0.05},
+			quotes: []Quote{
+				{Source: "bad", Price: "150", TradeTime: 1000},
+				{Source: "a", Price: "100", TradeTime: 1001},
+				{Source: "b", Price: "101", TradeTime: 1002},
+				{Source: "c", Price: "100", TradeTime: 1003},
+				{Source: "d", Price: "99", TradeTime: 1004},
+				{Source: "bad", Price: "150", TradeTime: 1005},
+			},
+			expectedPrice:    "100.00000000",
+			expectedRejected: 1,
+		},
+		{
+			name:   "LastMethodComparesToMedian",
+			policy: AggregationPolicy{Method: LastMethod, Window: time.Second, MaxDeviation: 0.05},
+			quotes: []Quote{
+				{Source: "a", Price: "100", TradeTime: 1000},
+				{Source: "bad", Price: "150", TradeTime: 1001},
+				{Source: "b", Price: "100", TradeTime: 1002},
+				{Source: "c", Price: "101", TradeTime: 1003},
+			},
+			expectedPrice:    "101",
+			expectedRejected: 0,
+		},
+		{
+			name:   "OutlierAcceptedAfterWindow",
+			policy: AggregationPolicy{Method: LastMethod, Window: time.Second, MaxDeviation: 0.05},
+			quotes: []Quote{
+				{Source: "a", Price: "100", TradeTime: 1000},
+				{Source: "a", Price: "150", TradeTime: 3000},
+			},
+			expectedPrice: "150",
+		},
+		{
+			name:   "TradeIDsComparedPerSource",
+			policy: AggregationPolicy{Method: LastMethod},
+			quotes: []Quote{
+				{Source: "a", Price: "100", TradeID: 50, TradeTime: 1000},
+				{Source: "b", Price: "101", TradeID: 1, TradeTime: 1000},
+			},
+			expectedPrice: "101",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMarketWithConfig(Config{
+				DefaultPolicy:  AggregationPolicy{Method: LastMethod},
+				SymbolPolicies: map[string]AggregationPolicy{"BTCUSDT": tt.policy},
+			})
+			for _, quote := range tt.quotes {
+				m.UpdatePrice("BTCUSDT", quote)
+			}
+			assert.Equal(t, tt.expectedPrice, currentPrice(t, m, "BTCUSDT"))
+			assert.Equal(t, tt.expectedRejected, m.RejectedTicks("BTCUSDT"))
+			assert.Equal(t, uint64(0), m.DroppedTicks("BTCUSDT"))
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package outbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// Write stores an event in the outbox table using the caller's transaction, so the event
+// is persisted if and only if the surrounding balance change is committed.
+// The relay publishes it to RabbitMQ afterward.
+func Write(tx *sql.Tx, routingKey string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox payload: %w", err)
+	}
+
+	_, err = tx.Exec("INSERT INTO outbox (routing_key, payload) VALUES ($1, $2);", routingKey, body)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package outbox
+
+import (
+	"account-operator/postgresql"
+	"account-operator/quit"
+	"account-operator/rabbitmq"
+	"account-operator/schedule"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strconv"
+	"time"
+)
+
+type Relay interface {
+	Start()
+	Close()
+}
+
+func NewRelay() Relay {
+	pollInterval := viper.GetDuration("outbox.pollInterval")
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+	batchSize := viper.GetInt("outbox.batchSize")
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	retryCount := viper.GetInt("outbox.retryCount")
+	if retryCount == 0 {
+		retryCount = 3
+	}
+	retryInterval := viper.GetDuration("outbox.retryInterval")
+	if retryInterval <= 0 {
+		retryInterval = time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &relay{
+		exchangeName:  viper.GetString("outbox.exchangeName"),
+		pollInterval:  pollInterval,
+		batchSize:     batchSize,
+		retryCount:    retryCount,
+		retryInterval: retryInterval,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+type relay struct {
+	exchangeName  string
+	pollInterval  time.Duration
+	batchSize     int
+	retryCount    int
+	retryInterval time.Duration
+
+	// ch is only accessed from the relay goroutine
+	ch     *amqp091.Channel
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type message struct {
+	id         int64
+	routingKey string
+	payload    []byte
+}
+
+func (r *relay) Start() {
+	g := quit.ReportGoroutine("outbox relay")
+	go func() {
+		defer g.Done()
+		r.run()
+	}()
+}
+
+func (r *relay) run() {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	defer r.closeChannel()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			logrus.Info("Stopping outbox relay")
+			return
+		case <-ticker.C:
+			err := r.drain()
+			if err != nil && !errors.Is(err, context.Canceled) {
+				logrus.Errorf("Failed to relay outbox: %s", err)
+			}
+		}
+	}
+}
+
+// drain relays batches until the outbox is empty or a batch fails.
+func (r *relay) drain() error {
+	for {
+		delivered, err := r.relayBatch()
+		if err != nil {
+			return err
+		}
+		if delivered < r.batchSize {
+			return nil
+		}
+	}
+}
+
+// relayBatch publishes the oldest undelivered rows in order and marks them as delivered.
+// It stops at the first row that could not be published so events are never reordered.
+func (r *relay) relayBatch() (delivered int, err error) {
+	dbClient := postgresql.GetClient()
+
+	// Start a transaction
+	tx, err := dbClient.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the rows so that multiple instances never publish the same row concurrently
+	selectQuery := `
+		SELECT id, routing_key, payload
+		FROM outbox
+		WHERE delivered_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED;
+	`
+	rows, err := tx.Query(selectQuery, r.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	var messages []message
+	for rows.Next() {
+		var m message
+		err = rows.Scan(&m.id, &m.routingKey, &m.payload)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan outbox: %w", err)
+		}
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	var publishErr error
+	for _, m := range messages {
+		publishErr = r.publish(m)
+		if publishErr != nil {
+			break
+		}
+		_, err = tx.Exec("UPDATE outbox SET delivered_at = NOW() WHERE id = $1;", m.id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to mark outbox as delivered: %w", err)
+		}
+		delivered++
+	}
+
+	// Commit the transaction
+	if commitErr := tx.Commit(); commitErr != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", commitErr)
+	}
+	if publishErr != nil {
+		return delivered, fmt.Errorf("failed to publish outbox message %d: %w", messages[delivered].id, publishErr)
+	}
+	return delivered, nil
+}
+
+func (r *relay) publish(m message) error {
+	return schedule.Retry(r.ctx, r.retryCount, r.retryInterval, func(round int) bool {
+		ch, err := r.channel()
+		if err != nil {
+			logrus.Errorf("Failed to open outbox channel[%d]: %s", round, err)
+			return false
+		}
+
+		confirmation, err := ch.PublishWithDeferredConfirmWithContext(r.ctx, r.exchangeName, m.routingKey, false, false, amqp091.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp091.Persistent,
+			MessageId:    strconv.FormatInt(m.id, 10),
+			Timestamp:    time.Now(),
+			Body:         m.payload,
+		})
+		if err != nil {
+			logrus.Errorf("Failed to publish outbox message %d[%d]: %s", m.id, round, err)
+			return false
+		}
+
+		acked, err := confirmation.WaitContext(r.ctx)
+		if err != nil {
+			logrus.Errorf("Failed to wait for confirmation of outbox message %d[%d]: %s", m.id, round, err)
+			return false
+		}
+		if !acked {
+			logrus.Errorf("Outbox message %d was nacked by the broker[%d]", m.id, round)
+			return false
+		}
+		return true
+	})
+}
+
+// channel returns a confirm-mode channel, reopening it if the previous one was closed.
+func (r *relay) channel() (*amqp091.Channel, error) {
+	if r.ch != nil && !r.ch.IsClosed() {
+		return r.ch, nil
+	}
+
+	ch, err := rabbitmq.NewChannel(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = ch.ExchangeDeclare(
+		r.exchangeName, // name
+		"topic",        // type
+		true,           // durable
+		false,          // auto-deleted
+		false,          // internal
+		false,          // no-wait
+		nil,            // arguments
+	)
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+	err = ch.Confirm(false)
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+	r.ch = ch
+	return ch, nil
+}
+
+func (r *relay) closeChannel() {
+	if r.ch == nil || r.ch.IsClosed() {
+		return
+	}
+	err := r.ch.Close()
+	if err != nil {
+		logrus.Errorf("Failed to close outbox channel: %s", err)
+	}
+}
+
+func (r *relay) Close() {
+	r.cancel()
+}
@@ -0,0 +1,3 @@
+-- The quoted symbols a trade price was derived from, e.g. [{"symbol":"ETHUSDT","price":"2000"},{"symbol":"USDTEUR","price":"0.9"}]
+ALTER TABLE transfer_log
+    ADD COLUMN IF NOT EXISTS route JSONB NOT NULL DEFAULT '[]';
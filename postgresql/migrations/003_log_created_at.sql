@@ -0,0 +1,9 @@
+-- Statements and PnL order movements by time
+ALTER TABLE transfer_log
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE deposit_and_withdrawal_log
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS transfer_log_from_account_idx ON transfer_log (from_account, created_at);
+CREATE INDEX IF NOT EXISTS transfer_log_to_account_idx ON transfer_log (to_account, created_at);
+CREATE INDEX IF NOT EXISTS deposit_and_withdrawal_log_account_idx ON deposit_and_withdrawal_log (account, created_at);
@@ -0,0 +1,14 @@
+-- none: active, debit: deposits only, full: nothing allowed
+ALTER TABLE account
+    ADD COLUMN IF NOT EXISTS freeze_state  TEXT NOT NULL DEFAULT 'none' CHECK (freeze_state IN ('none', 'debit', 'full')),
+    ADD COLUMN IF NOT EXISTS freeze_reason TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS account_freeze_log
+(
+    id           BIGSERIAL PRIMARY KEY,
+    account      TEXT        NOT NULL,
+    freeze_state TEXT        NOT NULL,
+    reason       TEXT        NOT NULL,
+    actor        TEXT        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
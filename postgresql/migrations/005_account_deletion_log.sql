@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS account_deletion_log
+(
+    id           BIGSERIAL PRIMARY KEY,
+    account      TEXT        NOT NULL,
+    -- delete or restore
+    action       TEXT        NOT NULL,
+    actor        TEXT        NOT NULL,
+    sweep_to     TEXT        NOT NULL DEFAULT '',
+    swept_amount NUMERIC(21, 8) NOT NULL DEFAULT 0,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
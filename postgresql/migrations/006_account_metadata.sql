@@ -0,0 +1,7 @@
+ALTER TABLE account
+    ADD COLUMN IF NOT EXISTS labels     TEXT[]  NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS is_default BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Names are unique per owner, and each owner has at most one default account per currency
+CREATE UNIQUE INDEX IF NOT EXISTS account_owner_name_idx ON account (owner, name) WHERE NOT is_deleted;
+CREATE UNIQUE INDEX IF NOT EXISTS account_owner_default_idx ON account (owner, currency) WHERE is_default AND NOT is_deleted;
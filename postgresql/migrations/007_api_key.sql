@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS api_key
+(
+    id          TEXT PRIMARY KEY,
+    owner       TEXT        NOT NULL,
+    label       TEXT        NOT NULL DEFAULT '',
+    -- AES-GCM sealed HMAC secret, the plain secret is only shown once at creation
+    secret      BYTEA       NOT NULL,
+    permissions TEXT[]      NOT NULL,
+    allowed_ips TEXT[]      NOT NULL DEFAULT '{}',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at  TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS api_key_owner_idx ON api_key (owner);
@@ -0,0 +1,19 @@
+-- Single tokens revoked by jti, rows are purged once the token would have expired anyway
+CREATE TABLE IF NOT EXISTS revoked_token
+(
+    jti        TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL,
+    reason     TEXT        NOT NULL DEFAULT '',
+    actor      TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Tokens of user_id issued before revoked_before are invalid
+CREATE TABLE IF NOT EXISTS revoked_session
+(
+    user_id        TEXT PRIMARY KEY,
+    revoked_before TIMESTAMPTZ NOT NULL,
+    reason         TEXT        NOT NULL DEFAULT '',
+    actor          TEXT        NOT NULL,
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,29 @@
+-- Funds of pending withdrawals move from balance to held_balance until they are decided
+ALTER TABLE account
+    ADD COLUMN IF NOT EXISTS held_balance NUMERIC(21, 8) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS pending_withdrawal
+(
+    id           BIGSERIAL PRIMARY KEY,
+    account      TEXT           NOT NULL,
+    amount       NUMERIC(21, 8) NOT NULL,
+    requested_by TEXT           NOT NULL,
+    status       TEXT           NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected', 'expired')),
+    decided_by   TEXT           NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ    NOT NULL DEFAULT NOW(),
+    expires_at   TIMESTAMPTZ    NOT NULL,
+    decided_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS pending_withdrawal_pending_idx ON pending_withdrawal (expires_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS withdrawal_approval_log
+(
+    id         BIGSERIAL PRIMARY KEY,
+    withdrawal BIGINT      NOT NULL,
+    -- request, approve, reject or expire
+    action     TEXT        NOT NULL,
+    actor      TEXT        NOT NULL,
+    reason     TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,10 @@
+-- Users without a row use the default tier of limits.tiers
+CREATE TABLE IF NOT EXISTS user_limit_tier
+(
+    user_id    TEXT PRIMARY KEY,
+    tier       TEXT        NOT NULL,
+    actor      TEXT        NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS pending_withdrawal_account_idx ON pending_withdrawal (account, created_at) WHERE status = 'pending';
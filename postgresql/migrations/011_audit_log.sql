@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id           BIGSERIAL PRIMARY KEY,
+    created_at   TIMESTAMPTZ NOT NULL,
+    actor        TEXT        NOT NULL,
+    roles        TEXT[]      NOT NULL,
+    api_key      TEXT        NOT NULL,
+    ip           TEXT        NOT NULL,
+    user_agent   TEXT        NOT NULL,
+    method       TEXT        NOT NULL,
+    endpoint     TEXT        NOT NULL,
+    -- SHA-256 of the request body
+    payload_hash TEXT        NOT NULL,
+    status       INTEGER     NOT NULL,
+    account_ids  TEXT[]      NOT NULL,
+    -- hash covers every column above and prev_hash, so editing or removing a row breaks the chain
+    prev_hash    TEXT        NOT NULL,
+    hash         TEXT        NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor, id);
+CREATE INDEX IF NOT EXISTS audit_log_account_ids_idx ON audit_log USING GIN (account_ids);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE
+    ON audit_log
+    FOR EACH STATEMENT
+EXECUTE FUNCTION audit_log_append_only();
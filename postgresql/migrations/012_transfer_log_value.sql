@@ -0,0 +1,5 @@
+-- The worth of a trade in pnl.valueCurrency at settlement time, so PnL keeps a historical cost basis.
+-- Both are NULL for sweeps and for trades that had no price in that currency.
+ALTER TABLE transfer_log
+    ADD COLUMN IF NOT EXISTS value_currency TEXT,
+    ADD COLUMN IF NOT EXISTS value NUMERIC;
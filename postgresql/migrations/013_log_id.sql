@@ -0,0 +1,5 @@
+-- Movements logged within the same timestamp are ordered by id, so statements and PnL are repeatable
+ALTER TABLE transfer_log
+    ADD COLUMN IF NOT EXISTS id BIGSERIAL;
+ALTER TABLE deposit_and_withdrawal_log
+    ADD COLUMN IF NOT EXISTS id BIGSERIAL;
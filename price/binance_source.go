@@ -0,0 +1,142 @@
+package price
+
+import (
+	"account-operator/quit"
+	"context"
+	"fmt"
+	"github.com/adshao/go-binance/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sync"
+	"time"
+)
+
+// NewBinanceSource creates a Source reading trades directly from the Binance websocket streams.
+func NewBinanceSource() Source {
+	reconnectInterval := viper.GetDuration("price.binance.reconnectInterval")
+	if reconnectInterval <= 0 {
+		reconnectInterval = time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &binanceSource{
+		symbols:           configuredSymbols(),
+		reconnectInterval: reconnectInterval,
+		streams:           make(map[string]*binanceStream),
+		ctx:               ctx,
+		cancel:            cancel,
+	}
+}
+
+type binanceSource struct {
+	symbols           []string
+	reconnectInterval time.Duration
+
+	lock    sync.Mutex
+	streams map[string]*binanceStream
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type binanceStream struct {
+	symbol string
+	out    chan Tick
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (b *binanceSource) Start(_ context.Context) (Feeds, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var feeds = make(Feeds)
+	for _, symbol := range b.symbols {
+		feed, err := b.subscribe(symbol)
+		if err != nil {
+			return nil, err
+		}
+		feeds[symbol] = feed
+	}
+	return feeds, nil
+}
+
+func (b *binanceSource) Subscribe(symbol string) (<-chan Tick, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.subscribe(symbol)
+}
+
+// subscribe must be called with lock held.
+func (b *binanceSource) subscribe(symbol string) (<-chan Tick, error) {
+	if _, exists := b.streams[symbol]; exists {
+		return nil, ErrSymbolAlreadySubscribed
+	}
+	ctx, cancel := context.WithCancel(b.ctx)
+	stream := &binanceStream{
+		symbol: symbol,
+		out:    make(chan Tick),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	b.streams[symbol] = stream
+
+	g := quit.ReportGoroutine(fmt.Sprintf("binance source for symbol %s", symbol))
+	go func() {
+		defer g.Done()
+		defer close(stream.out)
+		b.serve(stream)
+	}()
+	return stream.out, nil
+}
+
+// serve keeps a websocket open for stream until it is stopped, reconnecting when it drops.
+func (b *binanceSource) serve(stream *binanceStream) {
+	handler := func(event *binance.WsTradeEvent) {
+		select {
+		case stream.out <- newTick(BinanceSource, *event):
+		case <-stream.ctx.Done():
+			countDropped(stream.symbol)
+		}
+	}
+	errHandler := func(err error) {
+		logrus.Errorf("Binance trade stream of symbol %s failed: %s", stream.symbol, err)
+	}
+
+	for {
+		doneC, stopC, err := binance.WsTradeServe(stream.symbol, handler, errHandler)
+		if err != nil {
+			logrus.Errorf("Failed to connect binance trade stream of symbol %s: %s", stream.symbol, err)
+		} else {
+			select {
+			case <-doneC:
+				logrus.Errorf("Binance trade stream of symbol %s closed, reconnecting...", stream.symbol)
+			case <-stream.ctx.Done():
+				close(stopC)
+				// Wait for the handler to return before the feed is closed
+				<-doneC
+				return
+			}
+		}
+
+		select {
+		case <-stream.ctx.Done():
+			return
+		case <-time.After(b.reconnectInterval):
+		}
+	}
+}
+
+func (b *binanceSource) Unsubscribe(symbol string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	stream, exists := b.streams[symbol]
+	if !exists {
+		return ErrSymbolNotSubscribed
+	}
+	delete(b.streams, symbol)
+	stream.cancel()
+	return nil
+}
+
+func (b *binanceSource) Close() {
+	b.cancel()
+}
@@ -1,48 +1,213 @@
 package price
 
 import (
+	"account-operator/protocol"
+	"account-operator/quit"
 	"account-operator/rabbitmq"
+	"account-operator/schedule"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"sync"
+	"time"
 )
 
-type PriceReceiver interface {
-	Start(ctx context.Context) (Delivers, error)
-	Close()
-}
-
-func NewPriceReceiver() PriceReceiver {
+// NewPriceReceiver creates a Source consuming protocol.CoinPriceBody messages from RabbitMQ.
+// Subscribing a symbol binds its routing key, and unsubscribing closes its feed.
+func NewPriceReceiver() Source {
 	exchangeName := viper.GetString("receiver.exchangeName")
-	symbols := viper.GetStringSlice("receiver.symbols")
+	symbols := configuredSymbols()
+	reconnectInterval := viper.GetDuration("receiver.reconnectInterval")
+	if reconnectInterval <= 0 {
+		reconnectInterval = time.Second
+	}
+	prefetch := viper.GetInt("receiver.prefetch")
+	if prefetch <= 0 {
+		prefetch = 100
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &priceReceiver{
-		exchangeName: exchangeName,
-		symbols:      symbols,
+		exchangeName:       exchangeName,
+		symbols:            symbols,
+		reconnectInterval:  reconnectInterval,
+		prefetch:           prefetch,
+		deadLetterExchange: viper.GetString("receiver.deadLetterExchange"),
+		deadLetterQueue:    viper.GetString("receiver.deadLetterQueue"),
+		streams:            make(map[string]*symbolStream),
+		ctx:                ctx,
+		cancel:             cancel,
 	}
 }
 
 type priceReceiver struct {
-	ch           *amqp091.Channel
-	exchangeName string
-	symbols      []string
+	exchangeName      string
+	symbols           []string
+	reconnectInterval time.Duration
+	prefetch          int
+	// deadLetterExchange receives malformed messages, they are discarded if it is empty
+	deadLetterExchange string
+	// deadLetterQueue keeps the dead-lettered messages for inspection if set
+	deadLetterQueue string
+
+	// lock guards ch and streams, which are replaced on reconnection
+	lock    sync.Mutex
+	ch      *amqp091.Channel
+	streams map[string]*symbolStream
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// symbolStream owns the feed handed out for a symbol. The AMQP consumer
+// feeding it can be swapped after a reconnection without the reader noticing.
+type symbolStream struct {
+	symbol string
+	// queue is the server-named queue currently bound for symbol
+	queue string
+	out   chan Tick
+	swap  chan (<-chan amqp091.Delivery)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newSymbolStream(parent context.Context, symbol string, queue string) *symbolStream {
+	ctx, cancel := context.WithCancel(parent)
+	return &symbolStream{
+		symbol: symbol,
+		queue:  queue,
+		out:    make(chan Tick),
+		swap:   make(chan (<-chan amqp091.Delivery)),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// pump forwards ticks from the current consumer to out until the stream is stopped.
+func (s *symbolStream) pump(src <-chan amqp091.Delivery) {
+	defer close(s.out)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case newSrc := <-s.swap:
+			logrus.Infof("Price consumer for symbol %s resubscribed", s.symbol)
+			src = newSrc
+		case msg, ok := <-src:
+			if !ok {
+				// The consumer is gone, wait for the reconnection to hand over a new one
+				src = nil
+				continue
+			}
+			var coinPriceBody protocol.CoinPriceBody
+			err := json.Unmarshal(msg.Body, &coinPriceBody)
+			if err != nil {
+				logrus.Errorf("Failed to unmarshal price of symbol %s, dead-lettering it: %s", s.symbol, err)
+				countInvalid(s.symbol)
+				// Never requeue, the message would fail the same way again
+				if rejectErr := msg.Reject(false); rejectErr != nil {
+					logrus.Errorf("Failed to reject price of symbol %s: %s", s.symbol, rejectErr)
+				}
+				continue
+			}
+			tick := newTickFromCoinPriceBody(AMQPSource, coinPriceBody)
+			tick.ack = func() error {
+				return msg.Ack(false)
+			}
+			select {
+			case s.out <- tick:
+			case newSrc := <-s.swap:
+				// Nobody read the tick before the reconnection, its delivery died with the old channel
+				logrus.Infof("Price consumer for symbol %s resubscribed", s.symbol)
+				countDropped(s.symbol)
+				src = newSrc
+			case <-s.ctx.Done():
+				// The exclusive queue goes away with the consumer, so the message is lost
+				countDropped(s.symbol)
+				return
+			}
+		}
+	}
+}
+
+func (p *priceReceiver) openChannel(ctx context.Context) (*amqp091.Channel, error) {
+	ch, err := rabbitmq.NewChannel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = ch.Qos(p.prefetch, 0, false)
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+	err = p.declareDeadLetter(ch)
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+	return ch, nil
 }
 
-type Delivers map[string]<-chan amqp091.Delivery
+func (p *priceReceiver) declareDeadLetter(ch *amqp091.Channel) error {
+	if p.deadLetterExchange == "" {
+		return nil
+	}
+	err := ch.ExchangeDeclare(
+		p.deadLetterExchange, // name
+		"fanout",             // type
+		true,                 // durable
+		false,                // auto-deleted
+		false,                // internal
+		false,                // no-wait
+		nil,                  // arguments
+	)
+	if err != nil {
+		return err
+	}
+	if p.deadLetterQueue == "" {
+		return nil
+	}
+	_, err = ch.QueueDeclare(
+		p.deadLetterQueue, // name
+		true,              // durable
+		false,             // delete when unused
+		false,             // exclusive
+		false,             // no-wait
+		nil,               // arguments
+	)
+	if err != nil {
+		return err
+	}
+	return ch.QueueBind(
+		p.deadLetterQueue,    // queue name
+		"",                   // routing key
+		p.deadLetterExchange, // exchange
+		false,
+		nil,
+	)
+}
 
-func (p *priceReceiver) makeDeliveryChan(symbol string) (symbolCh <-chan amqp091.Delivery, err error) {
-	q, err := p.ch.QueueDeclare(
+func (p *priceReceiver) makeDeliveryChan(ch *amqp091.Channel, symbol string) (queue string, symbolCh <-chan amqp091.Delivery, err error) {
+	var args amqp091.Table
+	if p.deadLetterExchange != "" {
+		args = amqp091.Table{"x-dead-letter-exchange": p.deadLetterExchange}
+	}
+	q, err := ch.QueueDeclare(
 		"",    // name
 		false, // durable
 		true,  // delete when unused
 		true,  // exclusive
 		false, // no-wait
-		nil,   // arguments
+		args,  // arguments
 	)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
-	err = p.ch.QueueBind(
+	err = ch.QueueBind(
 		q.Name,         // queue name
 		symbol,         // routing key
 		p.exchangeName, // exchange
@@ -50,45 +215,198 @@ func (p *priceReceiver) makeDeliveryChan(symbol string) (symbolCh <-chan amqp091
 		nil,
 	)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
-	symbolCh, err = p.ch.Consume(
+	symbolCh, err = ch.Consume(
 		q.Name, // queue
-		"",     // consumer
-		true,   // auto-ack
+		symbol, // consumer
+		false,  // auto-ack
 		true,   // exclusive
 		false,  // no-local
 		false,  // no-wait
 		nil,    // args
 	)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
-	return symbolCh, nil
+	return q.Name, symbolCh, nil
 }
 
-func (p *priceReceiver) Start(ctx context.Context) (Delivers, error) {
+func (p *priceReceiver) Start(ctx context.Context) (Feeds, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
 	var err error
-	p.ch, err = rabbitmq.NewChannel(ctx)
+	p.ch, err = p.openChannel(ctx)
 	if err != nil {
 		return nil, err
 	}
-	err = p.ch.Qos(100, 0, false)
-	if err != nil {
-		return nil, err
-	}
-	var ds = make(Delivers)
+	var ds = make(Feeds)
 	for _, symbol := range p.symbols {
-		symbolCh, makeDeliveryChanErr := p.makeDeliveryChan(symbol)
-		if makeDeliveryChanErr != nil {
-			return nil, makeDeliveryChanErr
+		symbolCh, subscribeErr := p.subscribe(symbol)
+		if subscribeErr != nil {
+			return nil, subscribeErr
 		}
 		ds[symbol] = symbolCh
 	}
+
+	g := quit.ReportGoroutine("price receiver watcher")
+	go func(closeCh chan *amqp091.Error) {
+		defer g.Done()
+		p.watch(closeCh)
+	}(p.ch.NotifyClose(make(chan *amqp091.Error, 1)))
 	return ds, nil
 }
 
+func (p *priceReceiver) Subscribe(symbol string) (<-chan Tick, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.subscribe(symbol)
+}
+
+// subscribe must be called with lock held.
+func (p *priceReceiver) subscribe(symbol string) (<-chan Tick, error) {
+	if _, exists := p.streams[symbol]; exists {
+		return nil, ErrSymbolAlreadySubscribed
+	}
+	if p.ch == nil {
+		return nil, errors.New("price receiver is not started")
+	}
+
+	queue, src, err := p.makeDeliveryChan(p.ch, symbol)
+	if err != nil {
+		return nil, err
+	}
+	stream := newSymbolStream(p.ctx, symbol, queue)
+	p.streams[symbol] = stream
+
+	g := quit.ReportGoroutine(fmt.Sprintf("price receiver for symbol %s", symbol))
+	go func() {
+		defer g.Done()
+		stream.pump(src)
+	}()
+	return stream.out, nil
+}
+
+func (p *priceReceiver) Unsubscribe(symbol string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	stream, exists := p.streams[symbol]
+	if !exists {
+		return ErrSymbolNotSubscribed
+	}
+	delete(p.streams, symbol)
+	stream.cancel()
+	if p.ch == nil || p.ch.IsClosed() {
+		// The queue went away with the channel, a reconnection will not bind it again
+		return nil
+	}
+
+	// The queue is exclusive and auto-deleted, so cancelling the consumer is enough to drop it.
+	// Unbind first so no message is routed to it in between.
+	err := p.ch.QueueUnbind(stream.queue, symbol, p.exchangeName, nil)
+	if err != nil {
+		return fmt.Errorf("failed to unbind symbol %s: %w", symbol, err)
+	}
+	err = p.ch.Cancel(symbol, false)
+	if err != nil {
+		return fmt.Errorf("failed to cancel consumer of symbol %s: %w", symbol, err)
+	}
+	return nil
+}
+
+// watch reconnects whenever the channel is closed by the broker or by a connection loss.
+func (p *priceReceiver) watch(closeCh chan *amqp091.Error) {
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case amqpErr, ok := <-closeCh:
+			if !ok || amqpErr == nil {
+				// Closed on purpose
+				return
+			}
+			logrus.Errorf("Price receiver channel closed: %s, reconnecting...", amqpErr)
+			var err error
+			closeCh, err = p.reconnect()
+			if err != nil {
+				logrus.Errorf("Failed to reconnect price receiver: %s", err)
+				return
+			}
+			logrus.Info("Price receiver reconnected")
+		}
+	}
+}
+
+// handover is a new consumer for the pump of stream.
+type handover struct {
+	stream *symbolStream
+	src    <-chan amqp091.Delivery
+}
+
+// reconnect re-dials, redeclares and rebinds a queue for every subscribed symbol,
+// then hands the new consumers over to the running pumps.
+func (p *priceReceiver) reconnect() (closeCh chan *amqp091.Error, err error) {
+	var handovers []handover
+	err = schedule.Retry(p.ctx, -1, p.reconnectInterval, func(round int) bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		ch, openErr := p.openChannel(p.ctx)
+		if openErr != nil {
+			logrus.Errorf("Failed to open price receiver channel[%d]: %s", round, openErr)
+			return false
+		}
+		queues := make(map[string]string, len(p.streams))
+		srcs := make(map[string]<-chan amqp091.Delivery, len(p.streams))
+		for symbol := range p.streams {
+			queue, symbolCh, makeDeliveryChanErr := p.makeDeliveryChan(ch, symbol)
+			if makeDeliveryChanErr != nil {
+				logrus.Errorf("Failed to resubscribe symbol %s[%d]: %s", symbol, round, makeDeliveryChanErr)
+				_ = ch.Close()
+				return false
+			}
+			queues[symbol] = queue
+			srcs[symbol] = symbolCh
+		}
+
+		p.ch = ch
+		closeCh = ch.NotifyClose(make(chan *amqp091.Error, 1))
+		handovers = make([]handover, 0, len(srcs))
+		for symbol, src := range srcs {
+			stream := p.streams[symbol]
+			stream.queue = queues[symbol]
+			handovers = append(handovers, handover{stream: stream, src: src})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Without the lock, so that a symbol can be unsubscribed while its pump is busy
+	handOver(handovers)
+	return closeCh, nil
+}
+
+// handOver gives each pump its new consumer, unless its stream was stopped meanwhile.
+func handOver(handovers []handover) {
+	for _, h := range handovers {
+		select {
+		case h.stream.swap <- h.src:
+		case <-h.stream.ctx.Done():
+		}
+	}
+}
+
 func (p *priceReceiver) Close() {
+	p.cancel()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.ch == nil {
+		return
+	}
 	err := p.ch.Close()
 	if err != nil {
 		logrus.Errorf("Failed to close reporter: %s", err)
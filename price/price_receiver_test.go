@@ -0,0 +1,122 @@
+package price
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAcknowledger records how each delivery tag was settled.
+type fakeAcknowledger struct {
+	lock     sync.Mutex
+	acked    []uint64
+	rejected map[uint64]bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, _ bool) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, _ bool, requeue bool) error {
+	return a.Reject(tag, requeue)
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.rejected[tag] = requeue
+	return nil
+}
+
+func (a *fakeAcknowledger) ackedTags() []uint64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return append([]uint64(nil), a.acked...)
+}
+
+func TestSymbolStreamAck(t *testing.T) {
+	const symbol = "ACKTESTUSDT"
+	acknowledger := &fakeAcknowledger{rejected: make(map[uint64]bool)}
+	src := make(chan amqp091.Delivery)
+	stream := newSymbolStream(context.Background(), symbol, "queue")
+	defer stream.cancel()
+	go stream.pump(src)
+
+	invalidBefore := GetStats().Symbols[symbol].Invalid
+	src <- amqp091.Delivery{Acknowledger: acknowledger, DeliveryTag: 1, Body: []byte("{not json")}
+	src <- amqp091.Delivery{Acknowledger: acknowledger, DeliveryTag: 2, Body: []byte(`{"wsTradeEvent":{"s":"ACKTESTUSDT","p":"1.5","q":"2","t":7,"T":100}}`)}
+
+	var tick Tick
+	select {
+	case tick = <-stream.out:
+	case <-time.After(time.Second):
+		t.Fatal("no tick delivered")
+	}
+	assert.Equal(t, "1.5", tick.Price)
+	assert.Equal(t, int64(7), tick.TradeID)
+
+	acknowledger.lock.Lock()
+	requeue, rejected := acknowledger.rejected[1]
+	acknowledger.lock.Unlock()
+	assert.True(t, rejected, "malformed messages are rejected")
+	assert.False(t, requeue, "malformed messages are not requeued")
+	assert.Equal(t, invalidBefore+1, GetStats().Symbols[symbol].Invalid)
+
+	// The tick stays unacked until whoever reads the feed is done with it
+	assert.Empty(t, acknowledger.ackedTags())
+	tick.Ack()
+	require.Equal(t, []uint64{2}, acknowledger.ackedTags())
+}
+
+func TestUnsubscribeDuringReconnect(t *testing.T) {
+	acknowledger := &fakeAcknowledger{rejected: make(map[uint64]bool)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := &priceReceiver{streams: make(map[string]*symbolStream), ctx: ctx, cancel: cancel}
+
+	// The runner of BUSYUSDT stopped reading, so its pump is stuck sending a tick
+	busy := newSymbolStream(ctx, "BUSYUSDT", "queue")
+	busySrc := make(chan amqp091.Delivery, 1)
+	busySrc <- amqp091.Delivery{Acknowledger: acknowledger, DeliveryTag: 1, Body: []byte(`{"wsTradeEvent":{"p":"1"}}`)}
+	go busy.pump(busySrc)
+	// The pump of IDLEUSDT is not running at all, so handing over to it blocks until it is stopped
+	idle := newSymbolStream(ctx, "IDLEUSDT", "queue")
+	p.streams["BUSYUSDT"] = busy
+	p.streams["IDLEUSDT"] = idle
+
+	handedOver := make(chan struct{})
+	go func() {
+		defer close(handedOver)
+		handOver([]handover{
+			{stream: busy, src: make(chan amqp091.Delivery)},
+			{stream: idle, src: make(chan amqp091.Delivery)},
+		})
+	}()
+
+	unsubscribed := make(chan error, 1)
+	go func() {
+		unsubscribed <- p.Unsubscribe("IDLEUSDT")
+	}()
+	select {
+	case err := <-unsubscribed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("unsubscribing blocked on the reconnection")
+	}
+	// The stuck pump takes its new consumer instead of waiting for the tick to be read
+	select {
+	case <-handedOver:
+	case <-time.After(time.Second):
+		t.Fatal("the reconnection did not finish")
+	}
+	assert.NoError(t, p.Unsubscribe("BUSYUSDT"))
+	assert.Empty(t, p.streams)
+}
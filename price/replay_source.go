@@ -0,0 +1,177 @@
+package price
+
+import (
+	"account-operator/protocol"
+	"account-operator/quit"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewReplaySource creates a Source replaying a JSONL file with one protocol.CoinPriceBody per line,
+// in file order, for deterministic local runs and backtests.
+// Lines of symbols that are not subscribed are skipped.
+func NewReplaySource() Source {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &replaySource{
+		path:     viper.GetString("price.replay.path"),
+		interval: viper.GetDuration("price.replay.interval"),
+		symbols:  configuredSymbols(),
+		streams:  make(map[string]*replayStream),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+type replaySource struct {
+	path string
+	// interval is the delay between two ticks, zero replays as fast as they are consumed
+	interval time.Duration
+	symbols  []string
+
+	lock    sync.Mutex
+	streams map[string]*replayStream
+	// finished is set once the replay is over, nothing is sent to later subscriptions
+	finished bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type replayStream struct {
+	out    chan Tick
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r *replaySource) Start(_ context.Context) (Feeds, error) {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay file: %w", err)
+	}
+
+	r.lock.Lock()
+	var feeds = make(Feeds)
+	for _, symbol := range r.symbols {
+		feed, subscribeErr := r.subscribe(symbol)
+		if subscribeErr != nil {
+			r.lock.Unlock()
+			_ = file.Close()
+			return nil, subscribeErr
+		}
+		feeds[symbol] = feed
+	}
+	r.lock.Unlock()
+
+	g := quit.ReportGoroutine("replay source")
+	go func() {
+		defer g.Done()
+		defer file.Close()
+		r.replay(file)
+	}()
+	return feeds, nil
+}
+
+func (r *replaySource) replay(file *os.File) {
+	// The feeds are only closed by the replaying goroutine, once nothing can be sent anymore
+	defer func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.finished = true
+		for symbol, stream := range r.streams {
+			close(stream.out)
+			delete(r.streams, symbol)
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var coinPriceBody protocol.CoinPriceBody
+		err := json.Unmarshal(scanner.Bytes(), &coinPriceBody)
+		if err != nil {
+			logrus.Errorf("Failed to unmarshal replay line %d: %s", line, err)
+			countInvalid(ReplaySource)
+			continue
+		}
+		tick := newTickFromCoinPriceBody(ReplaySource, coinPriceBody)
+
+		r.lock.Lock()
+		stream, exists := r.streams[tick.Symbol]
+		r.lock.Unlock()
+		if !exists {
+			continue
+		}
+
+		select {
+		case stream.out <- tick:
+		case <-stream.ctx.Done():
+			countDropped(tick.Symbol)
+		case <-r.ctx.Done():
+			return
+		}
+
+		if r.interval > 0 {
+			select {
+			case <-time.After(r.interval):
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.Errorf("Failed to read replay file: %s", err)
+		return
+	}
+	logrus.Infof("Replay of %s finished after %d lines", r.path, line)
+}
+
+func (r *replaySource) Subscribe(symbol string) (<-chan Tick, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.subscribe(symbol)
+}
+
+// subscribe must be called with lock held.
+func (r *replaySource) subscribe(symbol string) (<-chan Tick, error) {
+	if _, exists := r.streams[symbol]; exists {
+		return nil, ErrSymbolAlreadySubscribed
+	}
+	if r.finished {
+		// The replay is over, so the feed ends right away like the others did
+		out := make(chan Tick)
+		close(out)
+		return out, nil
+	}
+	ctx, cancel := context.WithCancel(r.ctx)
+	stream := &replayStream{
+		out:    make(chan Tick),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	r.streams[symbol] = stream
+	return stream.out, nil
+}
+
+func (r *replaySource) Unsubscribe(symbol string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stream, exists := r.streams[symbol]
+	if !exists {
+		return ErrSymbolNotSubscribed
+	}
+	delete(r.streams, symbol)
+	stream.cancel()
+	return nil
+}
+
+func (r *replaySource) Close() {
+	r.cancel()
+}
@@ -0,0 +1,44 @@
+package price
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaySubscribeAfterFinish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(`{"wsTradeEvent":{"s":"BTCUSDT","p":"1"}}`+"\n"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &replaySource{path: path, symbols: []string{"BTCUSDT"}, streams: make(map[string]*replayStream), ctx: ctx, cancel: cancel}
+	defer r.Close()
+	feeds, err := r.Start(ctx)
+	require.NoError(t, err)
+
+	drain := func(feed <-chan Tick) []Tick {
+		var ticks []Tick
+		for {
+			select {
+			case tick, ok := <-feed:
+				if !ok {
+					return ticks
+				}
+				ticks = append(ticks, tick)
+			case <-time.After(time.Second):
+				t.Fatal("the feed was not closed")
+			}
+		}
+	}
+	assert.Len(t, drain(feeds["BTCUSDT"]), 1)
+
+	// A symbol subscribed once the file is replayed gets a feed that ends instead of one that is never fed
+	feed, err := r.Subscribe("ETHUSDT")
+	require.NoError(t, err)
+	assert.Empty(t, drain(feed))
+}
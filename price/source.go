@@ -0,0 +1,110 @@
+package price
+
+import (
+	"account-operator/protocol"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/adshao/go-binance/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Tick is a single trade of a symbol, independent of the source it came from.
+type Tick struct {
+	Symbol    string
+	Price     string
+	Quantity  string
+	TradeID   int64
+	TradeTime int64
+	// Source names the feed the tick came from
+	Source string
+
+	// ack confirms the tick to its source once processed, nil when the source needs no confirmation
+	ack func() error
+}
+
+// WithAck returns a copy of t that calls ack once processed, for sources outside this package.
+func (t Tick) WithAck(ack func() error) Tick {
+	t.ack = ack
+	return t
+}
+
+// Ack confirms the tick has been processed, so the source does not deliver it again.
+func (t Tick) Ack() {
+	if t.ack == nil {
+		return
+	}
+	err := t.ack()
+	if err != nil {
+		logrus.Errorf("Failed to ack tick %d of symbol %s: %s", t.TradeID, t.Symbol, err)
+	}
+}
+
+// Feeds maps each symbol to the channel its ticks are delivered on.
+type Feeds map[string]<-chan Tick
+
+// Source delivers ticks of the subscribed symbols.
+type Source interface {
+	// Start subscribes the configured symbols
+	Start(ctx context.Context) (Feeds, error)
+	// Subscribe starts delivering ticks of symbol on the returned channel
+	Subscribe(symbol string) (<-chan Tick, error)
+	// Unsubscribe stops delivering ticks of symbol
+	Unsubscribe(symbol string) error
+	Close()
+}
+
+var (
+	ErrSymbolAlreadySubscribed = errors.New("symbol already subscribed")
+	ErrSymbolNotSubscribed     = errors.New("symbol not subscribed")
+)
+
+const (
+	AMQPSource    = "amqp"
+	BinanceSource = "binance"
+	ReplaySource  = "replay"
+)
+
+// NewSource creates the source selected by price.source, defaulting to RabbitMQ.
+func NewSource() (Source, error) {
+	sourceType := viper.GetString("price.source")
+	switch sourceType {
+	case "", AMQPSource:
+		return NewPriceReceiver(), nil
+	case BinanceSource:
+		return NewBinanceSource(), nil
+	case ReplaySource:
+		return NewReplaySource(), nil
+	default:
+		return nil, fmt.Errorf("unknown price source: %s", sourceType)
+	}
+}
+
+// configuredSymbols returns the symbols to subscribe at startup.
+// receiver.symbols is still honored for configurations that predate price.symbols.
+func configuredSymbols() []string {
+	if viper.IsSet("price.symbols") {
+		return viper.GetStringSlice("price.symbols")
+	}
+	return viper.GetStringSlice("receiver.symbols")
+}
+
+func newTick(source string, event binance.WsTradeEvent) Tick {
+	return Tick{
+		Symbol:    event.Symbol,
+		Price:     event.Price,
+		Quantity:  event.Quantity,
+		TradeID:   event.TradeID,
+		TradeTime: event.TradeTime,
+		Source:    source,
+	}
+}
+
+// newTickFromCoinPriceBody uses the source named in body, falling back to defaultSource.
+func newTickFromCoinPriceBody(defaultSource string, body protocol.CoinPriceBody) Tick {
+	if body.Source != "" {
+		return newTick(body.Source, body.WsTradeEvent)
+	}
+	return newTick(defaultSource, body.WsTradeEvent)
+}
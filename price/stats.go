@@ -0,0 +1,57 @@
+package price
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type counters struct {
+	invalid atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// symbol -> *counters
+var symbolCounters sync.Map
+
+func countersOf(symbol string) *counters {
+	c, _ := symbolCounters.LoadOrStore(symbol, &counters{})
+	return c.(*counters)
+}
+
+// countInvalid records a message that could not be parsed into a Tick.
+func countInvalid(symbol string) {
+	countersOf(symbol).invalid.Add(1)
+}
+
+// countDropped records a tick that was received but never handed to the operator.
+func countDropped(symbol string) {
+	countersOf(symbol).dropped.Add(1)
+}
+
+type SymbolStats struct {
+	Invalid uint64 `json:"invalid"`
+	Dropped uint64 `json:"dropped"`
+}
+
+type Stats struct {
+	Invalid uint64                 `json:"invalid"`
+	Dropped uint64                 `json:"dropped"`
+	Symbols map[string]SymbolStats `json:"symbols"`
+}
+
+// GetStats returns the invalid and dropped message counters since startup.
+func GetStats() Stats {
+	stats := Stats{Symbols: make(map[string]SymbolStats)}
+	symbolCounters.Range(func(key, value any) bool {
+		c := value.(*counters)
+		symbolStats := SymbolStats{
+			Invalid: c.invalid.Load(),
+			Dropped: c.dropped.Load(),
+		}
+		stats.Invalid += symbolStats.Invalid
+		stats.Dropped += symbolStats.Dropped
+		stats.Symbols[key.(string)] = symbolStats
+		return true
+	})
+	return stats
+}
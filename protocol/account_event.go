@@ -0,0 +1,24 @@
+package protocol
+
+const (
+	AccountCreatedEvent  = "account.created"
+	AccountDeletedEvent  = "account.deleted"
+	AccountRestoredEvent = "account.restored"
+	DepositEvent         = "account.deposit"
+	WithdrawEvent        = "account.withdraw"
+	TradeEvent           = "account.trade"
+)
+
+type AccountEventBody struct {
+	AccountID string `json:"account_id"`
+	Currency  string `json:"currency,omitempty"`
+	Amount    string `json:"amount,omitempty"`
+}
+
+type TradeEventBody struct {
+	FromAccountID string `json:"from_account_id"`
+	ToAccountID   string `json:"to_account_id"`
+	ExchangeRate  string `json:"exchange_rate"`
+	FromAmount    string `json:"from_amount"`
+	ToAmount      string `json:"to_amount"`
+}
@@ -4,4 +4,6 @@ import "github.com/adshao/go-binance/v2"
 
 type CoinPriceBody struct {
 	WsTradeEvent binance.WsTradeEvent `json:"wsTradeEvent"`
+	// Source names the exchange or publisher, so the same symbol can be fed by several of them
+	Source string `json:"source,omitempty"`
 }
@@ -8,12 +8,16 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 var conn atomic.Pointer[amqp.Connection]
 
+// reconnectLock makes sure concurrent users of a dropped connection only dial once
+var reconnectLock sync.Mutex
+
 func InitRabbitMQ(ctx context.Context) (err error) {
 	err = schedule.Retry(ctx, -1, time.Second, func(round int) bool {
 		var newConn *amqp.Connection
@@ -46,7 +50,7 @@ func NewChannel(ctx context.Context) (*amqp.Channel, error) {
 		// check if "channel/connection is not open"
 		if errors.Is(err, amqp.ErrClosed) {
 			logrus.Error("Connection is closed, reconnecting...")
-			errInitRabbitMQ := InitRabbitMQ(ctx)
+			errInitRabbitMQ := reconnect(ctx, currentConn)
 			if errInitRabbitMQ != nil {
 				logrus.Errorf("Failed to reconnect to RabbitMQ: %s", errInitRabbitMQ)
 				return nil, errInitRabbitMQ
@@ -71,6 +75,16 @@ func NewChannel(ctx context.Context) (*amqp.Channel, error) {
 	return newChannel, nil
 }
 
+// reconnect dials a new connection unless another caller already replaced closedConn.
+func reconnect(ctx context.Context, closedConn *amqp.Connection) error {
+	reconnectLock.Lock()
+	defer reconnectLock.Unlock()
+	if conn.Load() != closedConn {
+		return nil
+	}
+	return InitRabbitMQ(ctx)
+}
+
 func CloseRabbitMQ() {
 	currentConn := conn.Load()
 	if currentConn != nil {
@@ -0,0 +1,72 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+// cache mirrors the revocation tables so that checking a token never hits the database.
+type cache struct {
+	lock sync.RWMutex
+	// tokens maps a revoked jti to the time the token expires
+	tokens map[string]time.Time
+	// users maps a user_id to the time before which its tokens are revoked
+	users map[string]time.Time
+}
+
+func newCache() *cache {
+	return &cache{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]time.Time),
+	}
+}
+
+func (c *cache) replace(tokens map[string]time.Time, users map[string]time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.tokens = tokens
+	c.users = users
+}
+
+func (c *cache) revokeToken(jti string, expiresAt time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.tokens[jti] = expiresAt
+}
+
+func (c *cache) revokeUser(userID string, before time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// A later cutoff covers an earlier one
+	if before.After(c.users[userID]) {
+		c.users[userID] = before
+	}
+}
+
+// tokenRevoked reports whether jti was revoked.
+func (c *cache) tokenRevoked(jti string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	_, revoked := c.tokens[jti]
+	return revoked
+}
+
+// userRevokedBefore returns the cutoff of userID, if any.
+func (c *cache) userRevokedBefore(userID string) (time.Time, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	before, revoked := c.users[userID]
+	return before, revoked
+}
+
+// purge forgets tokens that expired before now. The revocations of users are kept, since the API
+// keys they cover never expire.
+func (c *cache) purge(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for jti, expiresAt := range c.tokens {
+		if expiresAt.Before(now) {
+			delete(c.tokens, jti)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package revocation
+
+import (
+	"account-operator/code"
+	"account-operator/postgresql"
+	"account-operator/quit"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"time"
+)
+
+type Checker interface {
+	// Check returns an error wrapping code.TokenRevoked if the token with claims was revoked
+	Check(claims jwt.MapClaims) error
+	// CheckUser returns an error wrapping code.TokenRevoked if the sessions of userID were
+	// revoked after a credential issued at issuedAt, such as an API key
+	CheckUser(userID string, issuedAt time.Time) error
+}
+
+type Store interface {
+	Checker
+	// Start loads the revocations and keeps the cache in sync with other instances
+	Start() error
+	Close()
+	// RevokeToken revokes the token with jti until it expires at expiresAt, or for revocation.tokenTTL
+	// when expiresAt is zero
+	RevokeToken(actorID string, jti string, expiresAt time.Time, reason string) error
+	// RevokeUser invalidates every token and API key of userID issued until now. It never expires,
+	// since API keys do not
+	RevokeUser(actorID string, userID string, reason string) error
+}
+
+func NewStore() Store {
+	refreshInterval := viper.GetDuration("revocation.refreshInterval")
+	if refreshInterval <= 0 {
+		refreshInterval = 10 * time.Second
+	}
+	// The default lifetime of a token revocation must cover the longest lived token
+	tokenTTL := viper.GetDuration("revocation.tokenTTL")
+	if tokenTTL <= 0 {
+		tokenTTL = 24 * time.Hour
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &store{
+		cache:           newCache(),
+		refreshInterval: refreshInterval,
+		tokenTTL:        tokenTTL,
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+}
+
+type store struct {
+	cache           *cache
+	refreshInterval time.Duration
+	tokenTTL        time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (s *store) Start() error {
+	err := s.load()
+	if err != nil {
+		return err
+	}
+	g := quit.ReportGoroutine("revocation refresher")
+	go func() {
+		defer g.Done()
+		s.run()
+	}()
+	return nil
+}
+
+func (s *store) run() {
+	ticker := time.NewTicker(s.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			logrus.Info("Stopping revocation refresher")
+			return
+		case <-ticker.C:
+			err := s.purge()
+			if err != nil {
+				logrus.Errorf("Failed to purge expired revocations: %s", err)
+			}
+			// Keep checking against the previous revocations if the database is unavailable
+			err = s.load()
+			if err != nil {
+				logrus.Errorf("Failed to refresh revocations: %s", err)
+			}
+		}
+	}
+}
+
+func (s *store) Close() {
+	s.cancel()
+}
+
+func (s *store) Check(claims jwt.MapClaims) error {
+	if jti, _ := claims["jti"].(string); jti != "" && s.cache.tokenRevoked(jti) {
+		return fmt.Errorf("%w : token %s", code.TokenRevoked, jti)
+	}
+
+	userID, _ := claims["user_id"].(string)
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		// Without iat there is no telling when the token was issued
+		return s.checkUser(userID, time.Time{}, 0)
+	}
+	// iat has a one second resolution, so a token issued during the second of the revocation is revoked too
+	return s.checkUser(userID, time.Unix(int64(iat), 0), time.Second)
+}
+
+func (s *store) CheckUser(userID string, issuedAt time.Time) error {
+	return s.checkUser(userID, issuedAt, 0)
+}
+
+// checkUser compares issuedAt with the revocation of userID at the resolution of the credential.
+func (s *store) checkUser(userID string, issuedAt time.Time, resolution time.Duration) error {
+	before, revoked := s.cache.userRevokedBefore(userID)
+	if revoked && !issuedAt.After(before.Truncate(resolution)) {
+		return fmt.Errorf("%w : sessions of user %s", code.TokenRevoked, userID)
+	}
+	return nil
+}
+
+func (s *store) RevokeToken(actorID string, jti string, expiresAt time.Time, reason string) error {
+	if jti == "" {
+		return fmt.Errorf("%w : jti is required", code.InvalidRequest)
+	}
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(s.tokenTTL)
+	}
+	query := `
+		INSERT INTO revoked_token (jti, expires_at, reason, actor)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (jti) DO NOTHING;
+	`
+	_, err := postgresql.GetClient().Exec(query, jti, expiresAt, reason, actorID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	s.cache.revokeToken(jti, expiresAt)
+	return nil
+}
+
+func (s *store) RevokeUser(actorID string, userID string, reason string) error {
+	if userID == "" {
+		return fmt.Errorf("%w : user_id is required", code.InvalidRequest)
+	}
+	// The exact time is kept, at the microsecond resolution of the database, so that an API key
+	// created earlier within the same second is revoked but a later one is not
+	before := time.Now().Truncate(time.Microsecond)
+	query := `
+		INSERT INTO revoked_session (user_id, revoked_before, reason, actor)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET revoked_before = GREATEST(revoked_session.revoked_before, EXCLUDED.revoked_before),
+		    reason = EXCLUDED.reason, actor = EXCLUDED.actor, updated_at = NOW();
+	`
+	_, err := postgresql.GetClient().Exec(query, userID, before, reason, actorID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	s.cache.revokeUser(userID, before)
+	return nil
+}
+
+// load replaces the cache with the unexpired revocations.
+func (s *store) load() error {
+	dbClient := postgresql.GetClient()
+
+	tokens := make(map[string]time.Time)
+	rows, err := dbClient.QueryContext(s.ctx, "SELECT jti, expires_at FROM revoked_token WHERE expires_at > NOW();")
+	if err != nil {
+		return fmt.Errorf("failed to query revoked tokens: %w", err)
+	}
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		err = rows.Scan(&jti, &expiresAt)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan revoked token: %w", err)
+		}
+		tokens[jti] = expiresAt
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to read revoked tokens: %w", err)
+	}
+
+	users := make(map[string]time.Time)
+	rows, err = dbClient.QueryContext(s.ctx, "SELECT user_id, revoked_before FROM revoked_session;")
+	if err != nil {
+		return fmt.Errorf("failed to query revoked sessions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID string
+		var before time.Time
+		err = rows.Scan(&userID, &before)
+		if err != nil {
+			return fmt.Errorf("failed to scan revoked session: %w", err)
+		}
+		users[userID] = before
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to read revoked sessions: %w", err)
+	}
+
+	s.cache.replace(tokens, users)
+	return nil
+}
+
+func (s *store) purge() error {
+	_, err := postgresql.GetClient().ExecContext(s.ctx, "DELETE FROM revoked_token WHERE expires_at <= NOW();")
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	s.cache.purge(time.Now())
+	return nil
+}
@@ -0,0 +1,85 @@
+package revocation
+
+import (
+	"account-operator/code"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	s := &store{cache: newCache()}
+	cutoff := time.Unix(1700000000, 0)
+	s.cache.revokeToken("stolen", cutoff.Add(time.Hour))
+	s.cache.revokeUser("banned", cutoff)
+
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		revoked bool
+	}{
+		{name: "NotRevoked", claims: jwt.MapClaims{"jti": "other", "user_id": "user", "iat": float64(cutoff.Unix())}},
+		{name: "RevokedJTI", claims: jwt.MapClaims{"jti": "stolen", "user_id": "user"}, revoked: true},
+		{name: "IssuedBeforeCutoff", claims: jwt.MapClaims{"user_id": "banned", "iat": float64(cutoff.Add(-time.Minute).Unix())}, revoked: true},
+		{name: "IssuedAtCutoff", claims: jwt.MapClaims{"user_id": "banned", "iat": float64(cutoff.Unix())}, revoked: true},
+		{name: "IssuedAfterCutoff", claims: jwt.MapClaims{"user_id": "banned", "iat": float64(cutoff.Add(time.Second).Unix())}},
+		{name: "RevokedUserWithoutIat", claims: jwt.MapClaims{"user_id": "banned"}, revoked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Check(tt.claims)
+			if tt.revoked {
+				assert.ErrorIs(t, err, code.TokenRevoked)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckUser(t *testing.T) {
+	s := &store{cache: newCache()}
+	cutoff := time.Unix(1700000000, 0)
+	s.cache.revokeUser("banned", cutoff)
+
+	assert.ErrorIs(t, s.CheckUser("banned", cutoff.Add(-time.Hour)), code.TokenRevoked)
+	assert.ErrorIs(t, s.CheckUser("banned", cutoff), code.TokenRevoked)
+	assert.NoError(t, s.CheckUser("banned", cutoff.Add(time.Millisecond)))
+	assert.NoError(t, s.CheckUser("user", cutoff.Add(-time.Hour)))
+}
+
+func TestRevokedWithinASecond(t *testing.T) {
+	s := &store{cache: newCache()}
+	second := time.Unix(1700000000, 0)
+	s.cache.revokeUser("banned", second.Add(500*time.Millisecond))
+
+	// API keys are compared at their own resolution
+	assert.ErrorIs(t, s.CheckUser("banned", second.Add(200*time.Millisecond)), code.TokenRevoked)
+	assert.NoError(t, s.CheckUser("banned", second.Add(600*time.Millisecond)))
+	// Tokens issued during that second may have been issued before the revocation
+	assert.ErrorIs(t, s.Check(jwt.MapClaims{"user_id": "banned", "iat": float64(second.Unix())}), code.TokenRevoked)
+	assert.NoError(t, s.Check(jwt.MapClaims{"user_id": "banned", "iat": float64(second.Add(time.Second).Unix())}))
+}
+
+func TestCache(t *testing.T) {
+	c := newCache()
+	now := time.Unix(1700000000, 0)
+
+	c.revokeUser("user", now)
+	c.revokeUser("user", now.Add(-time.Hour))
+	before, revoked := c.userRevokedBefore("user")
+	assert.True(t, revoked)
+	assert.Equal(t, now, before, "an earlier cutoff must not shorten a later one")
+
+	c.revokeToken("expired", now.Add(-time.Second))
+	c.revokeToken("live", now.Add(time.Second))
+	c.purge(now)
+	assert.False(t, c.tokenRevoked("expired"))
+	assert.True(t, c.tokenRevoked("live"))
+
+	c.purge(now.AddDate(10, 0, 0))
+	_, revoked = c.userRevokedBefore("user")
+	assert.True(t, revoked, "user revocations cover API keys, which never expire")
+}
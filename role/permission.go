@@ -0,0 +1,57 @@
+package role
+
+import "strings"
+
+type Permission = string
+
+const (
+	AccountRead      = "account:read"
+	AccountWrite     = "account:write"
+	TradeExecute     = "trade:execute"
+	FundsDeposit     = "funds:deposit"
+	FundsWithdraw    = "funds:withdraw"
+	AdminAccounts    = "admin:accounts"
+	AdminSymbols     = "admin:symbols"
+	AdminPrice       = "admin:price"
+	AdminTokens      = "admin:tokens"
+	AdminWithdrawals = "admin:withdrawals"
+	AdminLimits      = "admin:limits"
+	AdminAudit       = "admin:audit"
+
+	// AdminAll grants every admin:* permission
+	AdminAll = "admin:*"
+)
+
+// permissionMatrix maps a token role to the permissions it grants.
+// A permission ending with ":*" grants every permission of that scope.
+var permissionMatrix = map[Role][]Permission{
+	Admin:  {AccountRead, AccountWrite, TradeExecute, FundsDeposit, FundsWithdraw, AdminAll},
+	Reader: {AccountRead},
+}
+
+// Permissions returns the permissions granted by roles. Unknown roles grant nothing.
+func Permissions(roles []Role) []Permission {
+	var permissions []Permission
+	for _, r := range roles {
+		permissions = append(permissions, permissionMatrix[r]...)
+	}
+	return permissions
+}
+
+// Grants reports whether granted contains required, directly or through a scope wildcard.
+func Grants(granted []Permission, required Permission) bool {
+	for _, p := range granted {
+		if p == required {
+			return true
+		}
+		if scope, ok := strings.CutSuffix(p, "*"); ok && strings.HasSuffix(scope, ":") && strings.HasPrefix(required, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether any of roles grants required.
+func HasPermission(roles []Role, required Permission) bool {
+	return Grants(Permissions(roles), required)
+}
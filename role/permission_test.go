@@ -0,0 +1,37 @@
+package role
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		name     string
+		roles    []Role
+		required Permission
+		expected bool
+	}{
+		{name: "ReaderCanRead", roles: []Role{Reader}, required: AccountRead, expected: true},
+		{name: "ReaderCannotWrite", roles: []Role{Reader}, required: AccountWrite, expected: false},
+		{name: "ReaderCannotTrade", roles: []Role{Reader}, required: TradeExecute, expected: false},
+		{name: "AdminCanWithdraw", roles: []Role{Admin}, required: FundsWithdraw, expected: true},
+		{name: "AdminWildcard", roles: []Role{Admin}, required: AdminSymbols, expected: true},
+		{name: "AdminWildcardOtherScope", roles: []Role{Reader}, required: AdminPrice, expected: false},
+		{name: "RolesAreCombined", roles: []Role{Reader, Admin}, required: TradeExecute, expected: true},
+		{name: "UnknownRole", roles: []Role{"guest"}, required: AccountRead, expected: false},
+		{name: "NoRole", roles: nil, required: AccountRead, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, HasPermission(tt.roles, tt.required))
+		})
+	}
+}
+
+func TestGrantsWildcardNeedsScope(t *testing.T) {
+	assert.True(t, Grants([]Permission{"admin:*"}, "admin:anything"))
+	assert.False(t, Grants([]Permission{"admin:*"}, "administrator"))
+	assert.False(t, Grants([]Permission{"adm*"}, "admin:price"))
+}
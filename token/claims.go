@@ -0,0 +1,142 @@
+package token
+
+import (
+	"account-operator/code"
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/spf13/viper"
+	"time"
+)
+
+// ClaimsConfig holds the registered claims every token must satisfy.
+type ClaimsConfig struct {
+	// Issuer must equal iss if set
+	Issuer string
+	// Audience must intersect aud if set, so tokens issued for other services are rejected
+	Audience []string
+	// Leeway tolerates clock skew with the issuer when checking exp, nbf and iat
+	Leeway time.Duration
+}
+
+var claimsConfig ClaimsConfig
+
+// now is replaced in tests
+var now = time.Now
+
+func loadClaimsConfig() ClaimsConfig {
+	return ClaimsConfig{
+		Issuer:   viper.GetString("token.issuer"),
+		Audience: viper.GetStringSlice("token.audience"),
+		Leeway:   viper.GetDuration("token.leeway"),
+	}
+}
+
+// SetClaimsConfig replaces the claim requirements checked by VerifyToken.
+func SetClaimsConfig(config ClaimsConfig) {
+	claimsConfig = config
+}
+
+// validateClaims checks exp, which is mandatory, then nbf, iat, iss and aud.
+func validateClaims(claims jwt.MapClaims, config ClaimsConfig) error {
+	current := now()
+
+	exp, present, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !present {
+		return fmt.Errorf("%w : exp is required", code.InvalidToken)
+	}
+	if !current.Before(exp.Add(config.Leeway)) {
+		return fmt.Errorf("%w : expired at %s", code.TokenExpired, exp.UTC().Format(time.RFC3339))
+	}
+
+	nbf, present, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if present && current.Add(config.Leeway).Before(nbf) {
+		return fmt.Errorf("%w : valid from %s", code.TokenNotYetValid, nbf.UTC().Format(time.RFC3339))
+	}
+
+	iat, present, err := numericDate(claims, "iat")
+	if err != nil {
+		return err
+	}
+	if present && current.Add(config.Leeway).Before(iat) {
+		return fmt.Errorf("%w : issued in the future", code.InvalidToken)
+	}
+
+	if config.Issuer != "" {
+		iss, _ := claims["iss"].(string)
+		if iss != config.Issuer {
+			return fmt.Errorf("%w : %q", code.TokenWrongIssuer, iss)
+		}
+	}
+
+	if len(config.Audience) > 0 {
+		aud, err := audience(claims)
+		if err != nil {
+			return err
+		}
+		if !intersects(aud, config.Audience) {
+			return fmt.Errorf("%w : %v", code.TokenWrongAudience, aud)
+		}
+	}
+	return nil
+}
+
+func numericDate(claims jwt.MapClaims, name string) (time.Time, bool, error) {
+	value, exist := claims[name]
+	if !exist {
+		return time.Time{}, false, nil
+	}
+	var seconds float64
+	switch v := value.(type) {
+	case float64:
+		seconds = v
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("%w : %s is not a number", code.InvalidToken, name)
+		}
+		seconds = f
+	default:
+		return time.Time{}, false, fmt.Errorf("%w : %s is not a number", code.InvalidToken, name)
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true, nil
+}
+
+// audience reads aud, which is either a single audience or an array of audiences.
+func audience(claims jwt.MapClaims) ([]string, error) {
+	switch aud := claims["aud"].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{aud}, nil
+	case []interface{}:
+		result := make([]string, len(aud))
+		for i, a := range aud {
+			s, ok := a.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w : aud is not a string array", code.InvalidToken)
+			}
+			result[i] = s
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("%w : aud is not a string array", code.InvalidToken)
+	}
+}
+
+func intersects(a []string, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package token
+
+import (
+	"account-operator/code"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateClaims(t *testing.T) {
+	current := time.Unix(1700000000, 0)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	unix := func(d time.Duration) float64 {
+		return float64(current.Add(d).Unix())
+	}
+	config := ClaimsConfig{Issuer: "auth", Audience: []string{"account-operator"}, Leeway: 30 * time.Second}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"exp": unix(time.Hour), "iss": "auth", "aud": "account-operator"}
+	}
+
+	tests := []struct {
+		name     string
+		mutate   func(jwt.MapClaims)
+		config   ClaimsConfig
+		expected error
+	}{
+		{name: "Valid", mutate: func(jwt.MapClaims) {}, config: config},
+		{name: "MissingExp", mutate: func(c jwt.MapClaims) { delete(c, "exp") }, config: config, expected: code.InvalidToken},
+		{name: "NonNumericExp", mutate: func(c jwt.MapClaims) { c["exp"] = "tomorrow" }, config: config, expected: code.InvalidToken},
+		{name: "Expired", mutate: func(c jwt.MapClaims) { c["exp"] = unix(-time.Minute) }, config: config, expected: code.TokenExpired},
+		{name: "ExpiredWithinLeeway", mutate: func(c jwt.MapClaims) { c["exp"] = unix(-10 * time.Second) }, config: config},
+		{name: "ExpiredWithoutLeeway", mutate: func(c jwt.MapClaims) { c["exp"] = unix(-10 * time.Second) }, config: ClaimsConfig{}, expected: code.TokenExpired},
+		{name: "NotYetValid", mutate: func(c jwt.MapClaims) { c["nbf"] = unix(time.Minute) }, config: config, expected: code.TokenNotYetValid},
+		{name: "NotBeforeWithinLeeway", mutate: func(c jwt.MapClaims) { c["nbf"] = unix(10 * time.Second) }, config: config},
+		{name: "IssuedInFuture", mutate: func(c jwt.MapClaims) { c["iat"] = unix(time.Minute) }, config: config, expected: code.InvalidToken},
+		{name: "WrongIssuer", mutate: func(c jwt.MapClaims) { c["iss"] = "other" }, config: config, expected: code.TokenWrongIssuer},
+		{name: "MissingIssuer", mutate: func(c jwt.MapClaims) { delete(c, "iss") }, config: config, expected: code.TokenWrongIssuer},
+		{name: "OtherService", mutate: func(c jwt.MapClaims) { c["aud"] = "billing" }, config: config, expected: code.TokenWrongAudience},
+		{name: "MissingAudience", mutate: func(c jwt.MapClaims) { delete(c, "aud") }, config: config, expected: code.TokenWrongAudience},
+		{name: "AudienceArray", mutate: func(c jwt.MapClaims) { c["aud"] = []interface{}{"billing", "account-operator"} }, config: config},
+		{name: "AudienceNotChecked", mutate: func(c jwt.MapClaims) { c["aud"] = "billing" }, config: ClaimsConfig{Issuer: "auth"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.mutate(claims)
+			err := validateClaims(claims, tt.config)
+			if tt.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.expected)
+		})
+	}
+}
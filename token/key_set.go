@@ -0,0 +1,273 @@
+package token
+
+import (
+	"account-operator/quit"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultKeyID is the kid of the key from token.publicKeyPath, used by tokens without a kid header.
+const defaultKeyID = ""
+
+const jwksFetchTimeout = 10 * time.Second
+
+// KeyConfig tells where verification keys are loaded from. Every configured location is merged.
+type KeyConfig struct {
+	// PublicKeyPath is a single PEM public key, used for tokens without a kid
+	PublicKeyPath string
+	// KeyDir holds PEM public keys, the kid of each key is its file name without extension
+	KeyDir string
+	// JWKS is a JWKS document, either a local file or an http(s) URL
+	JWKS string
+	// RefreshInterval reloads KeyDir and JWKS periodically if positive
+	RefreshInterval time.Duration
+}
+
+type keySet struct {
+	lock sync.RWMutex
+	keys map[string]crypto.PublicKey
+}
+
+var (
+	verifyKeys    = &keySet{keys: map[string]crypto.PublicKey{}}
+	refreshCancel context.CancelFunc
+)
+
+// InitVerifyKey loads the verification keys and claim requirements configured under token.*
+// and starts refreshing the keys.
+func InitVerifyKey() error {
+	SetClaimsConfig(loadClaimsConfig())
+	return InitVerifyKeyWithConfig(KeyConfig{
+		PublicKeyPath:   viper.GetString("token.publicKeyPath"),
+		KeyDir:          viper.GetString("token.keyDir"),
+		JWKS:            viper.GetString("token.jwks"),
+		RefreshInterval: viper.GetDuration("token.refreshInterval"),
+	})
+}
+
+func InitVerifyKeyWithConfig(config KeyConfig) error {
+	keys, err := loadKeys(config)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("no verification key configured")
+	}
+	verifyKeys.replace(keys)
+
+	if config.RefreshInterval > 0 && (config.KeyDir != "" || config.JWKS != "") {
+		var ctx context.Context
+		ctx, refreshCancel = context.WithCancel(context.Background())
+		g := quit.ReportGoroutine("verify key refresher")
+		go func() {
+			defer g.Done()
+			refresh(ctx, config)
+		}()
+	}
+	return nil
+}
+
+// CloseVerifyKey stops refreshing the verification keys.
+func CloseVerifyKey() {
+	if refreshCancel != nil {
+		refreshCancel()
+	}
+}
+
+// SetVerifyKey replaces all verification keys with key, used for tokens without a kid.
+// A nil key removes every key.
+func SetVerifyKey(key crypto.PublicKey) {
+	keys := map[string]crypto.PublicKey{}
+	if key != nil {
+		keys[defaultKeyID] = key
+	}
+	verifyKeys.replace(keys)
+}
+
+func refresh(ctx context.Context, config KeyConfig) {
+	ticker := time.NewTicker(config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			keys, err := loadKeys(config)
+			if err != nil {
+				// Keep verifying with the previous keys rather than rejecting every token
+				logrus.Errorf("Failed to refresh verification keys: %s", err)
+				continue
+			}
+			verifyKeys.replace(keys)
+			logrus.Debugf("Refreshed %d verification keys", len(keys))
+		}
+	}
+}
+
+func (s *keySet) replace(keys map[string]crypto.PublicKey) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.keys = keys
+}
+
+// lookup returns the key for kid. A token without a kid uses the default key,
+// or the only key if there is exactly one.
+func (s *keySet) lookup(kid string) (crypto.PublicKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.keys) == 0 {
+		return nil, errors.New("verify key is not initialized")
+	}
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == defaultKeyID && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func loadKeys(config KeyConfig) (map[string]crypto.PublicKey, error) {
+	keys := map[string]crypto.PublicKey{}
+	if config.PublicKeyPath != "" {
+		key, err := readPublicKeyFile(config.PublicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		keys[defaultKeyID] = key
+	}
+	if config.KeyDir != "" {
+		dirKeys, err := readKeyDir(config.KeyDir)
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range dirKeys {
+			keys[kid] = key
+		}
+	}
+	if config.JWKS != "" {
+		data, err := readJWKS(config.JWKS)
+		if err != nil {
+			return nil, err
+		}
+		jwksKeys, err := parseJWKS(data)
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range jwksKeys {
+			keys[kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func readKeyDir(dir string) (map[string]crypto.PublicKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key directory: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".pem") {
+			continue
+		}
+		key, readErr := readPublicKeyFile(filepath.Join(dir, entry.Name()))
+		if readErr != nil {
+			return nil, readErr
+		}
+		keys[strings.TrimSuffix(entry.Name(), ".pem")] = key
+	}
+	return keys, nil
+}
+
+func readPublicKeyFile(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key %s: %w", path, err)
+	}
+	key, err := parsePublicKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.New("unsupported public key format")
+	}
+	return cert.PublicKey, nil
+}
+
+func readJWKS(location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		data, err := os.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS: %w", err)
+		}
+		return data, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// checkSigningMethod makes sure the token alg matches the key type, so a key is never used with another algorithm.
+func checkSigningMethod(method jwt.SigningMethod, key crypto.PublicKey) error {
+	var ok bool
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, ok = method.(*jwt.SigningMethodRSA)
+	case *ecdsa.PublicKey:
+		_, ok = method.(*jwt.SigningMethodECDSA)
+	case ed25519.PublicKey:
+		_, ok = method.(*SigningMethodEdDSA)
+	}
+	if !ok {
+		return fmt.Errorf("unexpected signing method: %v", method.Alg())
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) jwk {
+	return jwk{Kid: kid, Kty: "RSA", Use: "sig", N: encode(key.N.Bytes()), E: encode(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) jwk {
+	return jwk{Kid: kid, Kty: "EC", Crv: "P-256", X: encode(key.X.Bytes()), Y: encode(key.Y.Bytes())}
+}
+
+func edJWK(kid string, key ed25519.PublicKey) jwk {
+	return jwk{Kid: kid, Kty: "OKP", Crv: "Ed25519", X: encode(key)}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, jwt.MapClaims{"user_id": "user", "exp": time.Now().Add(time.Hour).Unix()})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	tokenString, err := token.SignedString(key)
+	require.NoError(t, err)
+	return tokenString
+}
+
+// jwksStub serves a JWKS document that can be swapped to simulate a key rotation.
+type jwksStub struct {
+	lock     sync.Mutex
+	document jwks
+}
+
+func (s *jwksStub) set(keys ...jwk) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.document = jwks{Keys: keys}
+}
+
+func (s *jwksStub) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_ = json.NewEncoder(w).Encode(s.document)
+}
+
+func TestVerifyTokenWithJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	stub := &jwksStub{}
+	stub.set(rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("ec", &ecKey.PublicKey), edJWK("ed", edPublic))
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	require.NoError(t, InitVerifyKeyWithConfig(KeyConfig{JWKS: server.URL}))
+	defer SetVerifyKey(nil)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "RS256", token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey)},
+		{name: "ES256", token: sign(t, jwt.SigningMethodES256, "ec", ecKey)},
+		{name: "EdDSA", token: sign(t, EdDSA, "ed", edPrivate)},
+		{name: "UnknownKid", token: sign(t, jwt.SigningMethodRS256, "other", rsaKey), wantErr: true},
+		{name: "MissingKidWithSeveralKeys", token: sign(t, jwt.SigningMethodRS256, "", rsaKey), wantErr: true},
+		{name: "WrongKeyForKid", token: sign(t, jwt.SigningMethodES256, "rsa", ecKey), wantErr: true},
+		{name: "NoneAlgorithm", token: sign(t, jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := VerifyToken(tt.token)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "user", claims["user_id"])
+		})
+	}
+}
+
+func TestParseJWKSSkipsUnusableKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(jwks{Keys: []jwk{
+		rsaJWK("rsa", &rsaKey.PublicKey),
+		{Kid: "secp256k1", Kty: "EC", Crv: "secp256k1", X: encode([]byte{1}), Y: encode([]byte{2})},
+		{Kid: "malformed", Kty: "RSA", N: "not base64!", E: "AQAB"},
+		{Kid: "x448", Kty: "OKP", Crv: "X448", X: encode([]byte{1})},
+		{Kid: "symmetric", Kty: "oct"},
+		{Kid: "encryption", Kty: "RSA", Use: "enc", N: encode(rsaKey.N.Bytes()), E: "AQAB"},
+		edJWK("ed", edPublic),
+	}})
+	require.NoError(t, err)
+
+	keys, err := parseJWKS(data)
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Contains(t, keys, "rsa")
+	assert.Contains(t, keys, "ed")
+
+	data, err = json.Marshal(jwks{Keys: []jwk{{Kid: "secp256k1", Kty: "EC", Crv: "secp256k1"}}})
+	require.NoError(t, err)
+	_, err = parseJWKS(data)
+	assert.Error(t, err, "a JWKS without any usable key is an error")
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	stub := &jwksStub{}
+	stub.set(rsaJWK("old", &oldKey.PublicKey))
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	require.NoError(t, InitVerifyKeyWithConfig(KeyConfig{JWKS: server.URL, RefreshInterval: 10 * time.Millisecond}))
+	defer SetVerifyKey(nil)
+	defer CloseVerifyKey()
+
+	oldToken := sign(t, jwt.SigningMethodRS256, "old", oldKey)
+	newToken := sign(t, jwt.SigningMethodRS256, "new", newKey)
+	_, err = VerifyToken(oldToken)
+	assert.NoError(t, err)
+	_, err = VerifyToken(newToken)
+	assert.Error(t, err)
+
+	stub.set(rsaJWK("new", &newKey.PublicKey))
+	assert.Eventually(t, func() bool {
+		_, verifyErr := VerifyToken(newToken)
+		return verifyErr == nil
+	}, time.Second, 10*time.Millisecond)
+	_, err = VerifyToken(oldToken)
+	assert.Error(t, err)
+}
+
+func TestLoadKeyDir(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	writePEM := func(name string, key interface{}) {
+		der, marshalErr := x509.MarshalPKIXPublicKey(key)
+		require.NoError(t, marshalErr)
+		data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+	}
+	writePEM("2024.pem", &rsaKey.PublicKey)
+	writePEM("2025.pem", edPublic)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a key"), 0o600))
+
+	require.NoError(t, InitVerifyKeyWithConfig(KeyConfig{KeyDir: dir}))
+	defer SetVerifyKey(nil)
+
+	_, err = VerifyToken(sign(t, jwt.SigningMethodRS256, "2024", rsaKey))
+	assert.NoError(t, err)
+	_, err = VerifyToken(sign(t, EdDSA, "2025", edPrivate))
+	assert.NoError(t, err)
+	_, err = VerifyToken(sign(t, EdDSA, "2024", edPrivate))
+	assert.Error(t, err)
+}
+
+func TestInitVerifyKeyFails(t *testing.T) {
+	assert.Error(t, InitVerifyKeyWithConfig(KeyConfig{}))
+	assert.Error(t, InitVerifyKeyWithConfig(KeyConfig{PublicKeyPath: filepath.Join(t.TempDir(), "missing.pem")}))
+}
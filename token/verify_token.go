@@ -1,51 +1,44 @@
 package token
 
 import (
-	"crypto/rsa"
+	"account-operator/code"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
-	"log"
-	"os"
 )
 
-var (
-	verifyKey *rsa.PublicKey
-)
-
-func InitVerifyKey(verifyKeyFilePath string) {
-	// Read the private key
-	privateKeyData, err := os.ReadFile(verifyKeyFilePath)
-	if err != nil {
-		log.Fatalf("Error reading private key: %v", err)
-	}
+// parser skips the library's claim checks, validateClaims applies ours with leeway instead
+var parser = &jwt.Parser{SkipClaimsValidation: true}
 
-	// Parse the public key
-	verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(privateKeyData)
-	if err != nil {
-		log.Fatalf("Error parsing private key: %v", err)
-	}
-}
+// VerifyToken checks the signature and the registered claims of tokenString.
+// Errors wrap code.TokenExpired, code.TokenNotYetValid, code.TokenWrongIssuer,
+// code.TokenWrongAudience or code.InvalidToken.
 func VerifyToken(tokenString string) (jwt.MapClaims, error) {
-	if verifyKey == nil {
-		return nil, fmt.Errorf("verify key is not initialized")
-	}
-
-	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	token, err := parser.ParseWithClaims(tokenString, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := verifyKeys.lookup(kid)
+		if err != nil {
+			return nil, err
 		}
-		return verifyKey, nil
+		err = checkSigningMethod(token.Method, key)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w : %s", code.InvalidToken, err)
 	}
 	if !token.Valid {
-		return nil, fmt.Errorf("token is invalid")
+		return nil, fmt.Errorf("%w : token is invalid", code.InvalidToken)
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		return claims, nil
-	} else {
-		return nil, fmt.Errorf("failed to parse claims")
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("%w : failed to parse claims", code.InvalidToken)
+	}
+	err = validateClaims(claims, claimsConfig)
+	if err != nil {
+		return nil, err
 	}
+	return claims, nil
 }